@@ -0,0 +1,165 @@
+// Copyright 2022 Antrea Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package connection opens the connections of the monitors and the counter to Clickhouse
+// through the database/sql driver of clickhouse-go v1.
+package connection
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go"
+	"k8s.io/klog/v2"
+
+	"clickhouse/common/tlsconfig"
+)
+
+// The codes of the Clickhouse exceptions of a failed authentication.
+var authExceptionCodes = map[int32]bool{
+	192: true, // UNKNOWN_USER
+	193: true, // WRONG_PASSWORD
+	194: true, // REQUIRED_PASSWORD
+	516: true, // AUTHENTICATION_FAILED
+}
+
+// Backoff is the schedule of the connection attempts: the wait doubles after every
+// failed attempt up to Max, and a random part of up to half of it spreads the retries.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	// AttemptTimeout bounds every attempt, Timeout all of them.
+	AttemptTimeout time.Duration
+	Timeout        time.Duration
+}
+
+// Wait returns the time to wait after the failed attempt number attempt, from 0.
+func (b Backoff) Wait(attempt int) time.Duration {
+	wait := b.Initial
+	for i := 0; i < attempt && wait < b.Max; i++ {
+		wait *= 2
+	}
+	if wait > b.Max {
+		wait = b.Max
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// WithBackoff opens a single connection pool to Clickhouse and pings it until it
+// answers, retrying with the backoff until its timeout expires.
+func WithBackoff(dsn string, b Backoff) (*sql.DB, error) {
+	dsn, err := withDialTimeout(dsn, b.AttemptTimeout)
+	if err != nil {
+		return nil, err
+	}
+	connect, err := sql.Open("clickhouse", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the connection to clickhouse: %v", err)
+	}
+	deadline := time.Now().Add(b.Timeout)
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), b.AttemptTimeout)
+		err := connect.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return connect, nil
+		}
+		wait := b.Wait(attempt)
+		if time.Now().Add(wait).After(deadline) {
+			connect.Close()
+			return nil, fmt.Errorf("failed to connect to clickhouse after %d attempts in %s: %s", attempt+1, b.Timeout, DescribeError(err))
+		}
+		klog.Infof("failed to connect to clickhouse, retrying in %s: %s", wait.Round(time.Millisecond), DescribeError(err))
+		time.Sleep(wait)
+	}
+}
+
+// withDialTimeout sets the timeout parameter of the DSN to the attempt timeout unless
+// it sets it. The driver ignores the context of the ping while it resolves the host and
+// dials, the timeout parameter bounds the dial. read_timeout is left as the caller set
+// it, it applies to every query of the pool.
+func withDialTimeout(dsn string, attemptTimeout time.Duration) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid clickhouse DSN: %v", err)
+	}
+	query := u.Query()
+	if query.Get("timeout") == "" {
+		query.Set("timeout", strconv.FormatFloat(attemptTimeout.Seconds(), 'f', -1, 64))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// DescribeError describes a connection error by its cause: DNS lookup, refused
+// connection, timeout, authentication, or another Clickhouse exception.
+func DescribeError(err error) string {
+	var dnsErr *net.DNSError
+	var exception *clickhouse.Exception
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("DNS lookup of %s failed: %v", dnsErr.Name, err)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Sprintf("connection refused: %v", err)
+	case errors.As(err, &exception) && authExceptionCodes[exception.Code]:
+		return fmt.Sprintf("authentication failed: [%d] %s", exception.Code, exception.Message)
+	case errors.As(err, &exception):
+		return fmt.Sprintf("clickhouse exception: [%d] %s", exception.Code, exception.Message)
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Sprintf("timeout: %v", err)
+	default:
+		return err.Error()
+	}
+}
+
+// The name the TLS configuration is registered under in the driver, referenced by
+// the tls_config parameter of the DSN.
+const tlsConfigName = "clickhouse"
+
+// TLSParameters registers config with the driver and returns the DSN parameters
+// selecting it, or an empty string if config is nil.
+func TLSParameters(config *tls.Config) (string, error) {
+	if config == nil {
+		return "", nil
+	}
+	if err := clickhouse.RegisterTLSConfig(tlsConfigName, config); err != nil {
+		return "", err
+	}
+	return "&tls_config=" + tlsConfigName, nil
+}
+
+// NativePortAndTLSParameters returns the port of the Clickhouse native protocol, the
+// secure port 9440 with -tls, and the DSN parameters of the TLS configuration of the
+// flags, which is registered with the driver.
+func NativePortAndTLSParameters(flags *tlsconfig.Flags) (int, string, error) {
+	if !flags.Enabled {
+		return 9000, "", nil
+	}
+	config, err := flags.Config()
+	if err != nil {
+		return 0, "", err
+	}
+	params, err := TLSParameters(config)
+	return 9440, params, err
+}
@@ -0,0 +1,170 @@
+// Copyright 2022 Antrea Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connection
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go"
+
+	"clickhouse/common/tlsconfig"
+)
+
+func TestBackoffWait(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second}
+	for attempt, expected := range []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			if wait := b.Wait(attempt); wait < expected/2 || wait > expected {
+				t.Fatalf("expected a wait between %s and %s after attempt %d, got %s", expected/2, expected, attempt, wait)
+			}
+		}
+	}
+	// a large attempt number does not overflow
+	if wait := b.Wait(100); wait < 5*time.Second || wait > 10*time.Second {
+		t.Errorf("expected a wait between 5s and 10s, got %s", wait)
+	}
+}
+
+func TestDescribeConnectError(t *testing.T) {
+	// a port without listener refuses the connection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	_, refusedErr := net.Dial("tcp", addr)
+
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "DNS failure",
+			err:      &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "clickhouse.invalid"}},
+			expected: "DNS lookup of clickhouse.invalid failed",
+		},
+		{
+			name:     "connection refused",
+			err:      refusedErr,
+			expected: "connection refused",
+		},
+		{
+			name:     "authentication failure",
+			err:      &clickhouse.Exception{Code: 516, Message: "clickhouse_operator: Authentication failed"},
+			expected: "authentication failed: [516]",
+		},
+		{
+			name:     "clickhouse exception",
+			err:      &clickhouse.Exception{Code: 241, Message: "Memory limit exceeded"},
+			expected: "clickhouse exception: [241]",
+		},
+		{
+			name:     "attempt timeout",
+			err:      fmt.Errorf("ping: %w", context.DeadlineExceeded),
+			expected: "timeout",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if description := DescribeError(tc.err); !strings.HasPrefix(description, tc.expected) {
+				t.Errorf("expected a description starting with %q, got %q", tc.expected, description)
+			}
+		})
+	}
+}
+
+func TestConnectWithBackoffTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	start := time.Now()
+	_, err = WithBackoff("tcp://"+addr, Backoff{
+		Initial:        10 * time.Millisecond,
+		Max:            40 * time.Millisecond,
+		AttemptTimeout: time.Second,
+		Timeout:        200 * time.Millisecond,
+	})
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("expected a refused connection, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected to give up after the timeout of 200ms, took %s", elapsed)
+	}
+}
+
+func TestWithDialTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		dsn      string
+		expected string
+	}{
+		{
+			name:     "without timeout",
+			dsn:      "tcp://clickhouse:9000?debug=true&username=clickhouse_operator",
+			expected: "tcp://clickhouse:9000?debug=true&timeout=2.5&username=clickhouse_operator",
+		},
+		{
+			name:     "with a timeout",
+			dsn:      "tcp://clickhouse:9000?timeout=1",
+			expected: "tcp://clickhouse:9000?timeout=1",
+		},
+		{
+			name:     "with a read timeout",
+			dsn:      "tcp://clickhouse:9000?read_timeout=60",
+			expected: "tcp://clickhouse:9000?read_timeout=60&timeout=2.5",
+		},
+		{
+			name:     "without parameters",
+			dsn:      "tcp://clickhouse:9000",
+			expected: "tcp://clickhouse:9000?timeout=2.5",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dsn, err := withDialTimeout(tc.dsn, 2500*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dsn != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, dsn)
+			}
+		})
+	}
+}
+
+func TestNativePortAndTLSParameters(t *testing.T) {
+	port, params, err := NativePortAndTLSParameters(&tlsconfig.Flags{})
+	if err != nil || port != 9000 || params != "" {
+		t.Errorf("expected port 9000 without parameters, got %d %q %v", port, params, err)
+	}
+	port, params, err = NativePortAndTLSParameters(&tlsconfig.Flags{Enabled: true, ServerName: "clickhouse"})
+	if err != nil || port != 9440 || params != "&tls_config=clickhouse" {
+		t.Errorf("expected port 9440 with the TLS configuration, got %d %q %v", port, params, err)
+	}
+	if _, _, err := NativePortAndTLSParameters(&tlsconfig.Flags{Enabled: true, Cert: "client.pem"}); err == nil {
+		t.Error("expected an error for a certificate without key")
+	}
+}
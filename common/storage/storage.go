@@ -0,0 +1,200 @@
+// Copyright 2022 Antrea Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage measures the storage of Clickhouse and evicts the oldest records
+// when it runs full, for the monitor and the harness of the load generator.
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Store is the storage the monitor measures and evicts records from.
+type Store interface {
+	// DiskUsage returns the used and total bytes of the disk with the highest usage.
+	DiskUsage() (used, total uint64, err error)
+	// TableBytes returns the bytes on disk of the active parts of a table.
+	TableBytes(table string) (uint64, error)
+	// RowCount returns the number of rows of a table.
+	RowCount(table string) (uint64, error)
+	// Evict deletes up to the n oldest rows of a table and returns the id of the mutation
+	// deleting them. The space of the rows is released once the mutation is done.
+	Evict(table string, n uint64) (string, error)
+	// MutationStatus returns whether a mutation of a table is done.
+	MutationStatus(table, id string) (bool, error)
+}
+
+// ClickHouseStore is the Store of the tables of the default database of a Clickhouse
+// server. It only uses database/sql, so that it runs on both versions of clickhouse-go.
+type ClickHouseStore struct {
+	connect *sql.DB
+}
+
+func NewClickHouseStore(connect *sql.DB) *ClickHouseStore {
+	return &ClickHouseStore{connect: connect}
+}
+
+func (s *ClickHouseStore) DiskUsage() (uint64, uint64, error) {
+	rows, err := s.connect.Query("SELECT free_space, total_space FROM system.disks")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get the disk usage: %v", err)
+	}
+	defer rows.Close()
+	var used, total uint64
+	for rows.Next() {
+		var freeSpace, totalSpace uint64
+		if err := rows.Scan(&freeSpace, &totalSpace); err != nil {
+			return 0, 0, fmt.Errorf("failed to get the disk usage: %v", err)
+		}
+		if totalSpace == 0 {
+			continue
+		}
+		if total == 0 || float64(totalSpace-freeSpace)/float64(totalSpace) > float64(used)/float64(total) {
+			used, total = totalSpace-freeSpace, totalSpace
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("failed to get the disk usage: %v", err)
+	}
+	if total == 0 {
+		return 0, 0, fmt.Errorf("failed to get the disk usage: no disk with a size")
+	}
+	return used, total, nil
+}
+
+func (s *ClickHouseStore) TableBytes(table string) (uint64, error) {
+	var bytes uint64
+	if err := s.connect.QueryRow("SELECT sum(bytes_on_disk) FROM system.parts WHERE database = 'default' AND table = ? AND active", table).Scan(&bytes); err != nil {
+		return 0, fmt.Errorf("failed to get the size of %s: %v", table, err)
+	}
+	return bytes, nil
+}
+
+func (s *ClickHouseStore) RowCount(table string) (uint64, error) {
+	var count uint64
+	if err := s.connect.QueryRow(fmt.Sprintf("SELECT COUNT() FROM default.`%s`", table)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count the rows of %s: %v", table, err)
+	}
+	return count, nil
+}
+
+// Evict deletes the rows of the table inserted before the timeInserted of its row
+// number n in insertion order, i.e. up to its n oldest rows: the rows inserted in the
+// same second as the nth one are kept.
+func (s *ClickHouseStore) Evict(table string, n uint64) (string, error) {
+	// a table of n rows or less is emptied
+	condition := "1"
+	var cutoff time.Time
+	err := s.connect.QueryRow(fmt.Sprintf("SELECT timeInserted FROM default.`%s` ORDER BY timeInserted LIMIT 1 OFFSET %d", table, n)).Scan(&cutoff)
+	switch {
+	case err == nil:
+		condition = fmt.Sprintf("timeInserted < toDateTime(%d)", cutoff.Unix())
+	case err != sql.ErrNoRows:
+		return "", fmt.Errorf("failed to find the oldest rows of %s: %v", table, err)
+	}
+	alterCommand := fmt.Sprintf("ALTER TABLE default.`%s` DELETE WHERE %s", table, condition)
+	if _, err := s.connect.Exec(alterCommand); err != nil {
+		return "", fmt.Errorf("failed to delete the rows of %s: %v", table, err)
+	}
+	// the ALTER does not return the id of the mutation, which is the latest of the table
+	var id string
+	if err := s.connect.QueryRow(`SELECT mutation_id FROM system.mutations
+		WHERE database = 'default' AND table = ? ORDER BY create_time DESC, mutation_id DESC LIMIT 1`, table).Scan(&id); err != nil {
+		return "", fmt.Errorf("failed to find the mutation deleting the rows of %s: %v", table, err)
+	}
+	return id, nil
+}
+
+func (s *ClickHouseStore) MutationStatus(table, id string) (bool, error) {
+	var done uint8
+	var failReason string
+	if err := s.connect.QueryRow(`SELECT is_done, latest_fail_reason FROM system.mutations
+		WHERE database = 'default' AND table = ? AND mutation_id = ?`, table, id).Scan(&done, &failReason); err != nil {
+		return false, fmt.Errorf("failed to get the status of mutation %s of %s: %v", id, table, err)
+	}
+	if failReason != "" {
+		return false, fmt.Errorf("mutation %s of %s failed: %s", id, table, failReason)
+	}
+	return done == 1, nil
+}
+
+// InnerTable returns the name of the table storing the records of a materialized view
+// of a database: .inner_id.<uuid> in Atomic databases and .inner.<view> in Ordinary ones.
+func InnerTable(connect *sql.DB, database, view string) (string, error) {
+	var inner string
+	err := connect.QueryRow(`SELECT name FROM system.tables
+		WHERE database = ? AND (name = concat('.inner.', ?) OR name = concat('.inner_id.', toString(
+			(SELECT uuid FROM system.tables WHERE database = ? AND name = ?))))`,
+		database, view, database, view).Scan(&inner)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("materialized view %s.%s not found", database, view)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find the table of the view %s.%s: %v", database, view, err)
+	}
+	return inner, nil
+}
+
+// Usage returns the usage of the disk with the highest usage, between 0 and 1.
+func Usage(store Store) (float64, error) {
+	used, total, err := store.DiskUsage()
+	if err != nil {
+		return 0, err
+	}
+	return float64(used) / float64(total), nil
+}
+
+// Eviction is a deletion issued by MonitorMemory.
+type Eviction struct {
+	// Usage is the storage usage that caused the deletion.
+	Usage float64
+	// Rows is the number of rows selected for deletion out of Count.
+	Rows, Count uint64
+	// Mutation is the id of the mutation deleting the rows.
+	Mutation string
+}
+
+// MonitorMemory checks the storage usage and deletes the oldest deletePercentage of
+// the rows of table when it exceeds threshold. It returns the deletion, nil when no
+// records were deleted.
+func MonitorMemory(store Store, table string, threshold, deletePercentage float64) (*Eviction, error) {
+	used, total, err := store.DiskUsage()
+	if err != nil {
+		return nil, err
+	}
+	usagePercentage := float64(used) / float64(total)
+	klog.Infof("Memory usage: total %d, used: %d, percentage: %f", total, used, usagePercentage)
+	if usagePercentage <= threshold {
+		return nil, nil
+	}
+	count, err := store.RowCount(table)
+	if err != nil {
+		return nil, err
+	}
+	deleteRowNum := uint64(float64(count) * deletePercentage)
+	if deleteRowNum == 0 {
+		klog.Infof("No records to delete from %s", table)
+		return nil, nil
+	}
+	mutation, err := store.Evict(table, deleteRowNum)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("Deleting %d of %d records from %s in mutation %s", deleteRowNum, count, table, mutation)
+	return &Eviction{Usage: usagePercentage, Rows: deleteRowNum, Count: count, Mutation: mutation}, nil
+}
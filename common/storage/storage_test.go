@@ -0,0 +1,108 @@
+// Copyright 2022 Antrea Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage_test
+
+import (
+	"fmt"
+	"testing"
+
+	"clickhouse/common/storage"
+	"clickhouse/common/storage/storagetest"
+)
+
+// The table the tests evict rows from.
+const table = "flows"
+
+func TestMonitorMemory(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// rows of the monitored table, of 1 byte each on a disk of 1000 bytes
+		rows        uint64
+		err         string
+		expected    bool
+		evictedRows uint64
+	}{
+		{name: "below threshold", rows: 400},
+		{name: "at threshold", rows: 500},
+		{name: "above threshold", rows: 800, expected: true, evictedRows: 400},
+		{name: "disk usage failure", rows: 800, err: "DiskUsage"},
+		{name: "row count failure", rows: 800, err: "RowCount"},
+		{name: "eviction failure", rows: 800, err: "Evict"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := storagetest.NewMemStore(1000, 0)
+			store.AddTable(table, tc.rows, 1, 0)
+			if tc.err != "" {
+				store.Errors[tc.err] = fmt.Errorf("%s failed", tc.err)
+			}
+			eviction, err := storage.MonitorMemory(store, table, 0.5, 0.5)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err+" failed" {
+					t.Errorf("expected the error of %s, got %v", tc.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if (eviction != nil) != tc.expected {
+				t.Errorf("expected a deletion %t, got %v", tc.expected, eviction)
+			}
+			if rows := store.Tables[table].Rows; rows != tc.rows-tc.evictedRows {
+				t.Errorf("expected %d rows left, got %d", tc.rows-tc.evictedRows, rows)
+			}
+		})
+	}
+}
+
+func TestMonitorMemoryWithoutRows(t *testing.T) {
+	// the disk is full of other data than the monitored table
+	store := storagetest.NewMemStore(1000, 0)
+	store.BaseUsed = 900
+	store.AddTable(table, 1, 1, 0)
+	eviction, err := storage.MonitorMemory(store, table, 0.5, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eviction != nil || len(store.Mutations) != 0 {
+		t.Error("expected no deletion without records to delete")
+	}
+}
+
+func TestMonitorMemoryDelayedRelease(t *testing.T) {
+	store := storagetest.NewMemStore(1000, 2)
+	store.AddTable(table, 800, 1, 0)
+	eviction, err := storage.MonitorMemory(store, table, 0.5, 0.5)
+	if err != nil || eviction == nil {
+		t.Fatalf("expected a deletion, got %v, %v", eviction, err)
+	}
+	id := store.Mutations[0].ID
+	if eviction.Usage != 0.8 || eviction.Rows != 400 || eviction.Count != 800 || eviction.Mutation != id {
+		t.Errorf("expected the deletion of 400 of 800 rows at usage 0.8, got %+v", eviction)
+	}
+	for i := 0; i < 2; i++ {
+		if done, err := store.MutationStatus(table, id); err != nil || done {
+			t.Fatalf("expected mutation %s to be running after %d ticks, got %t, %v", id, i, done, err)
+		}
+		if usage, _ := storage.Usage(store); usage != 0.8 {
+			t.Errorf("expected the space to be released once the mutation is done, usage is %f after %d ticks", usage, i)
+		}
+		store.Tick()
+	}
+	if done, err := store.MutationStatus(table, id); err != nil || !done {
+		t.Fatalf("expected mutation %s to be done, got %t, %v", id, done, err)
+	}
+	if bytes, _ := store.TableBytes(table); bytes != 400 {
+		t.Errorf("expected 400 bytes left, got %d", bytes)
+	}
+}
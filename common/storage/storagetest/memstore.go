@@ -0,0 +1,169 @@
+// Copyright 2022 Antrea Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storagetest provides an in-memory storage.Store for tests.
+package storagetest
+
+import (
+	"fmt"
+)
+
+// MemStore is an in-memory storage.Store simulating the storage growth and the delayed
+// space release of Clickhouse: every tick adds the growth of the tables, and evicted
+// rows are only removed, releasing their space, once their mutation is done
+// ReleaseDelay ticks after the eviction.
+type MemStore struct {
+	Total uint64
+	// bytes used by other data than the tables
+	BaseUsed     uint64
+	ReleaseDelay int
+	Tables       map[string]*MemTable
+	Mutations    []*MemMutation
+	// errors returned by the methods of the same name
+	Errors map[string]error
+}
+
+type MemTable struct {
+	Rows     uint64
+	RowBytes uint64
+	// rows added by every tick
+	Growth uint64
+}
+
+type MemMutation struct {
+	ID    string
+	Table string
+	Rows  uint64
+	// ticks until the mutation is done
+	Remaining int
+}
+
+func NewMemStore(total uint64, releaseDelay int) *MemStore {
+	return &MemStore{
+		Total:        total,
+		ReleaseDelay: releaseDelay,
+		Tables:       map[string]*MemTable{},
+		Errors:       map[string]error{},
+	}
+}
+
+func (s *MemStore) AddTable(name string, rows, rowBytes, growth uint64) {
+	s.Tables[name] = &MemTable{Rows: rows, RowBytes: rowBytes, Growth: growth}
+}
+
+// Tick advances the simulation by one round: the due mutations are done, then the
+// tables grow.
+func (s *MemStore) Tick() {
+	for _, m := range s.Mutations {
+		if m.Remaining == 0 {
+			continue
+		}
+		m.Remaining--
+		if m.Remaining == 0 {
+			table := s.Tables[m.Table]
+			if m.Rows > table.Rows {
+				m.Rows = table.Rows
+			}
+			table.Rows -= m.Rows
+		}
+	}
+	for _, table := range s.Tables {
+		table.Rows += table.Growth
+	}
+}
+
+func (s *MemStore) used() uint64 {
+	used := s.BaseUsed
+	for _, table := range s.Tables {
+		used += table.Rows * table.RowBytes
+	}
+	if used > s.Total {
+		return s.Total
+	}
+	return used
+}
+
+func (s *MemStore) table(name string) (*MemTable, error) {
+	table, ok := s.Tables[name]
+	if !ok {
+		return nil, fmt.Errorf("table %s doesn't exist", name)
+	}
+	return table, nil
+}
+
+func (s *MemStore) DiskUsage() (uint64, uint64, error) {
+	if err := s.Errors["DiskUsage"]; err != nil {
+		return 0, 0, err
+	}
+	return s.used(), s.Total, nil
+}
+
+func (s *MemStore) TableBytes(name string) (uint64, error) {
+	if err := s.Errors["TableBytes"]; err != nil {
+		return 0, err
+	}
+	table, err := s.table(name)
+	if err != nil {
+		return 0, err
+	}
+	return table.Rows * table.RowBytes, nil
+}
+
+func (s *MemStore) RowCount(name string) (uint64, error) {
+	if err := s.Errors["RowCount"]; err != nil {
+		return 0, err
+	}
+	table, err := s.table(name)
+	if err != nil {
+		return 0, err
+	}
+	return table.Rows, nil
+}
+
+func (s *MemStore) Evict(name string, n uint64) (string, error) {
+	if err := s.Errors["Evict"]; err != nil {
+		return "", err
+	}
+	table, err := s.table(name)
+	if err != nil {
+		return "", err
+	}
+	m := &MemMutation{
+		ID:        fmt.Sprintf("mutation_%d.txt", len(s.Mutations)+1),
+		Table:     name,
+		Rows:      n,
+		Remaining: s.ReleaseDelay,
+	}
+	s.Mutations = append(s.Mutations, m)
+	// without delay the rows are removed right away
+	if m.Remaining == 0 {
+		if m.Rows > table.Rows {
+			m.Rows = table.Rows
+		}
+		table.Rows -= m.Rows
+	}
+	return m.ID, nil
+}
+
+func (s *MemStore) MutationStatus(name, id string) (bool, error) {
+	if err := s.Errors["MutationStatus"]; err != nil {
+		return false, err
+	}
+	for _, m := range s.Mutations {
+		if m.Table == name && m.ID == id {
+			return m.Remaining == 0, nil
+		}
+	}
+	return false, fmt.Errorf("mutation %s of %s doesn't exist", id, name)
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 Antrea Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tlsconfig loads the TLS configuration of the connections to Clickhouse from
+// the -tls flags shared by the load generator, the counter and the monitors.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Flags are the values of the -tls flags.
+type Flags struct {
+	// Enabled enables TLS on the connections to Clickhouse.
+	Enabled bool
+	// The PEM bundle of the CAs verifying the server, the client certificate and its
+	// key, and the name verified in the server certificate.
+	CA, Cert, Key, ServerName string
+}
+
+// Register defines the -tls flags on fs. usage is the help of -tls, which names the
+// secure ports the program switches to.
+func (f *Flags) Register(fs *flag.FlagSet, usage string) {
+	fs.BoolVar(&f.Enabled, "tls", false, usage)
+	fs.StringVar(&f.CA, "tls-ca", "", "PEM bundle of the CAs verifying the server certificate, the system CAs if empty")
+	fs.StringVar(&f.Cert, "tls-cert", "", "PEM client certificate, requires -tls-key")
+	fs.StringVar(&f.Key, "tls-key", "", "PEM key of the client certificate")
+	fs.StringVar(&f.ServerName, "tls-server-name", "", "name verified in the server certificate, the host if empty")
+}
+
+// Config returns the TLS configuration of the flags, nil without -tls.
+func (f *Flags) Config() (*tls.Config, error) {
+	if !f.Enabled {
+		return nil, nil
+	}
+	return New(f.CA, f.Cert, f.Key, f.ServerName)
+}
+
+// New builds the TLS configuration verifying the server with the CAs of the PEM
+// bundle ca, or the system CAs if empty, and presenting the client certificate of
+// cert and key if set.
+func New(ca, cert, key, serverName string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: serverName}
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", ca)
+		}
+	}
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, fmt.Errorf("a client certificate requires both -tls-cert and -tls-key")
+		}
+		certificate, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
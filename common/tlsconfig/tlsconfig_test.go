@@ -0,0 +1,201 @@
+// Copyright 2022 Antrea Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate is a certificate signed by a test CA, or self-signed without parent.
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCertificate(t *testing.T, name string, parent *testCertificate, usage x509.ExtKeyUsage) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		DNSNames:     []string{name},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{cert: cert, key: key}
+}
+
+// write writes the certificate and its key as PEM files to dir and returns their paths.
+func (c *testCertificate) write(t *testing.T, dir, name string) (string, string) {
+	certPath, keyPath := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// startTLSServer accepts TLS connections verified by the CA, requiring a client
+// certificate, and completes their handshakes.
+func startTLSServer(t *testing.T, ca, server *testCertificate) string {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCertificate()},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, "flow-visibility-test-ca", nil, 0)
+	caPath, _ := ca.write(t, dir, "ca")
+	server := newTestCertificate(t, "clickhouse.flow-visibility.svc", ca, x509.ExtKeyUsageServerAuth)
+	client := newTestCertificate(t, "clickhouse_operator", ca, x509.ExtKeyUsageClientAuth)
+	clientPath, clientKeyPath := client.write(t, dir, "client")
+	// a self-signed certificate of another CA
+	other := newTestCertificate(t, "other-ca", nil, 0)
+	otherPath, _ := other.write(t, dir, "other")
+	addr := startTLSServer(t, ca, server)
+
+	for _, tc := range []struct {
+		name          string
+		ca            string
+		cert          string
+		key           string
+		serverName    string
+		configErr     bool
+		handshakeFail bool
+	}{
+		{name: "CA, client certificate and server name", ca: caPath, cert: clientPath, key: clientKeyPath, serverName: "clickhouse.flow-visibility.svc"},
+		{name: "wrong server name", ca: caPath, cert: clientPath, key: clientKeyPath, serverName: "clickhouse.invalid", handshakeFail: true},
+		{name: "server of another CA", ca: otherPath, cert: clientPath, key: clientKeyPath, serverName: "clickhouse.flow-visibility.svc", handshakeFail: true},
+		{name: "missing client certificate", ca: caPath, serverName: "clickhouse.flow-visibility.svc", handshakeFail: true},
+		{name: "client certificate without key", ca: caPath, cert: clientPath, configErr: true},
+		{name: "missing CA file", ca: filepath.Join(dir, "missing.pem"), configErr: true},
+		{name: "CA file without certificate", ca: clientKeyPath, configErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := New(tc.ca, tc.cert, tc.key, tc.serverName)
+			if tc.configErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr, config)
+			if err == nil {
+				// with TLS 1.3 the server rejects the client certificate after the client
+				// handshake, and closes the connection after a successful handshake
+				_, err = conn.Read(make([]byte, 1))
+				conn.Close()
+			}
+			if tc.handshakeFail {
+				if err == io.EOF {
+					t.Error("expected the handshake to fail")
+				}
+			} else if err != io.EOF {
+				t.Errorf("expected the handshake to succeed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFlagsConfig(t *testing.T) {
+	var flags Flags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Register(fs, "connect over TLS")
+	if err := fs.Parse([]string{"-tls-server-name", "clickhouse.flow-visibility.svc"}); err != nil {
+		t.Fatal(err)
+	}
+	if config, err := flags.Config(); config != nil || err != nil {
+		t.Errorf("expected no configuration without -tls, got %v, %v", config, err)
+	}
+	if err := fs.Parse([]string{"-tls"}); err != nil {
+		t.Fatal(err)
+	}
+	config, err := flags.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config == nil || config.ServerName != "clickhouse.flow-visibility.svc" {
+		t.Errorf("expected the configuration of the flags, got %v", config)
+	}
+}
@@ -0,0 +1,72 @@
+#!/usr/bin/env bash
+
+# Copyright 2022 Antrea Authors
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Generates a self-signed CA, a ClickHouse server certificate and a client certificate
+# for testing the TLS connections of the monitor, the counter and the load generator.
+# usage: generate-certs.sh [output directory] [extra server name]...
+# example: ./generate-certs.sh certs && go run ./insert -h 127.0.0.1 -tls -tls-ca certs/ca.pem
+set -e
+
+OUT_DIR=${1:-certs}
+shift || true
+DAYS=365
+SERVER_NAMES="DNS:localhost,IP:127.0.0.1,DNS:clickhouse-clickhouse.flow-visibility.svc,DNS:clickhouse-clickhouse.flow-visibility.svc.cluster.local"
+for name in "$@"; do
+  SERVER_NAMES="${SERVER_NAMES},DNS:${name}"
+done
+
+mkdir -p "${OUT_DIR}"
+cd "${OUT_DIR}"
+
+echo "=== Generating the CA ==="
+openssl req -x509 -newkey rsa:2048 -nodes -days ${DAYS} \
+  -keyout ca-key.pem -out ca.pem -subj "/CN=flow-visibility-test-ca"
+
+echo "=== Generating the server certificate for ${SERVER_NAMES} ==="
+openssl req -newkey rsa:2048 -nodes -keyout server-key.pem -out server.csr -subj "/CN=clickhouse"
+openssl x509 -req -in server.csr -CA ca.pem -CAkey ca-key.pem -CAcreateserial -days ${DAYS} \
+  -out server.pem -extfile <(printf "subjectAltName=%s\nextendedKeyUsage=serverAuth" "${SERVER_NAMES}")
+
+echo "=== Generating the client certificate ==="
+openssl req -newkey rsa:2048 -nodes -keyout client-key.pem -out client.csr -subj "/CN=clickhouse_operator"
+openssl x509 -req -in client.csr -CA ca.pem -CAkey ca-key.pem -CAcreateserial -days ${DAYS} \
+  -out client.pem -extfile <(printf "extendedKeyUsage=clientAuth")
+
+rm -f server.csr client.csr ca.srl
+chmod 600 ca-key.pem server-key.pem client-key.pem
+
+cat > clickhouse-tls.xml <<-EOXML
+<clickhouse>
+    <tcp_port_secure>9440</tcp_port_secure>
+    <https_port>8443</https_port>
+    <openSSL>
+        <server>
+            <certificateFile>/etc/clickhouse-server/certs/server.pem</certificateFile>
+            <privateKeyFile>/etc/clickhouse-server/certs/server-key.pem</privateKeyFile>
+            <caConfig>/etc/clickhouse-server/certs/ca.pem</caConfig>
+            <!-- relaxed: client certificates are verified if presented, strict: required -->
+            <verificationMode>relaxed</verificationMode>
+            <loadDefaultCAFile>false</loadDefaultCAFile>
+            <cacheSessions>true</cacheSessions>
+            <preferServerCiphers>true</preferServerCiphers>
+        </server>
+    </openSSL>
+</clickhouse>
+EOXML
+
+echo "=== Certificates written to $(pwd) ==="
+echo "Copy ca.pem, server.pem and server-key.pem to /etc/clickhouse-server/certs/ and"
+echo "clickhouse-tls.xml to /etc/clickhouse-server/config.d/ to enable the secure ports 9440 and 8443."
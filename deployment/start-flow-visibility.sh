@@ -21,13 +21,15 @@ start_flow_visibility() {
   # install Clickhouse operator
   kubectl apply -f https://raw.githubusercontent.com/Altinity/clickhouse-operator/master/deploy/operator/clickhouse-operator-install-bundle.yaml
   kubectl create namespace flow-visibility
-  kubectl create configmap clickhouse-mounted-configmap -n flow-visibility --from-file=${THIS_DIR}/datasources/
   kubectl apply -f ${THIS_DIR}/flow-visibility-pv.yml -n flow-visibility
 
   echo "=== Waiting for Clickhouse to be ready ==="
   sleep 15
   kubectl wait --for=condition=ready pod -l app=clickhouse-operator -n kube-system --timeout=60s
   kubectl wait --for=condition=ready pod -l app=clickhouse -n flow-visibility --timeout=120s
+  # the image of the migration runner is built with
+  # docker build -f schema/Dockerfile -t flow-visibility-clickhouse-schema .
+  kubectl wait --for=condition=complete job/clickhouse-schema -n flow-visibility --timeout=120s
 
   CLICKHOUSE_HOST=$(kubectl get svc clickhouse-clickhouse -n flow-visibility -o jsonpath='{.spec.clusterIP}')
   echo "=== Clickhouse can be connected at ${CLICKHOUSE_HOST} ==="
@@ -0,0 +1,9 @@
+// Package e2e holds the end-to-end tests of the load generator and the monitor
+// against a local ClickHouse server, without Kubernetes.
+//
+// The tests start the server binary given by CLICKHOUSE_BINARY, either clickhouse-server
+// or the single clickhouse binary, and are skipped if it is not set. The TLS test also
+// requires openssl to generate its certificates:
+//
+//	CLICKHOUSE_BINARY=/usr/bin/clickhouse go test -v .
+package e2e
@@ -0,0 +1,181 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHarnessEvicts runs inserts with the storage monitor in-process and a threshold
+// below the disk usage, so that the monitor evicts on every round it does not skip. The
+// usage stays above the threshold, which fails the harness.
+func TestHarnessEvicts(t *testing.T) {
+	s := startServer(t)
+	s.applySchema(t)
+	insert := build(t, "../insert", "insert")
+
+	dir := t.TempDir()
+	code, out := runExitCode(t, dir, insert, "harness",
+		"-h", "127.0.0.1", "-port", fmt.Sprint(s.tcpPort),
+		"-r", "200", "-rate", "2000", "-c", "50",
+		"-threshold", "0.000001", "-delete-percentage", "0.5", "-skip-rounds", "1",
+		"-monitor-interval", "1s", "-mutation-check-interval", "200ms",
+		"-sample-interval", "1s", "-o", dir)
+	if code != 1 || !strings.Contains(out, "stayed above the threshold") || strings.Contains(out, "the monitor ran into") {
+		t.Errorf("expected the harness to fail on the usage above the threshold only, got exit code %d", code)
+	}
+
+	results, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(results) != 1 {
+		t.Fatalf("expected one result, got %v (%v)", results, err)
+	}
+	var result struct {
+		Summary struct {
+			RowsInserted int     `json:"rowsInserted"`
+			Availability float64 `json:"availability"`
+		} `json:"summary"`
+		Harness struct {
+			Evictions     int `json:"evictions"`
+			MonitorErrors int `json:"monitorErrors"`
+		} `json:"harness"`
+	}
+	data, err := os.ReadFile(results[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Summary.RowsInserted != 10000 || result.Summary.Availability != 1 {
+		t.Errorf("expected 10000 rows inserted without failures, got %d rows and availability %f",
+			result.Summary.RowsInserted, result.Summary.Availability)
+	}
+	if result.Harness.Evictions == 0 || result.Harness.MonitorErrors != 0 {
+		t.Errorf("expected evictions without monitor errors, got %d evictions and %d errors",
+			result.Harness.Evictions, result.Harness.MonitorErrors)
+	}
+	if !waitFor(time.Minute, func() bool {
+		return s.count(t, "SELECT COUNT() FROM system.mutations WHERE table = 'flows' AND NOT is_done") == 0
+	}) {
+		t.Fatal("the deletions did not finish")
+	}
+	if rows := s.count(t, "SELECT COUNT() FROM flows"); rows >= 10000 {
+		t.Errorf("expected evicted rows, the flows table has %d rows", rows)
+	}
+}
+
+// TestMonitorEvicts runs the monitor CronJob once in delete mode with a threshold below
+// the disk usage: it must delete the oldest half of the records of the flows table.
+func TestMonitorEvicts(t *testing.T) {
+	s := startServer(t)
+	s.applySchema(t)
+	monitor := build(t, "../monitor/cronjob_with_log_check/monitor", "monitor")
+
+	// 100 records inserted in each of the last 10 minutes
+	if _, err := s.connect.Exec(`INSERT INTO flows (timeInserted, flowStartSeconds, flowEndSeconds, sourcePodName)
+		SELECT now() - toIntervalMinute(number % 10), now(), now(), concat('pod-', toString(number)) FROM numbers(1000)`); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, t.TempDir(), monitor, "-addr", fmt.Sprintf("127.0.0.1:%d", s.tcpPort), "-threshold=0.000001")
+
+	if !waitFor(time.Minute, func() bool {
+		return s.count(t, "SELECT COUNT() FROM system.mutations WHERE table = 'flows' AND NOT is_done") == 0
+	}) {
+		t.Fatal("the deletion did not finish")
+	}
+	if rows := s.count(t, "SELECT COUNT() FROM flows"); rows != 500 {
+		t.Errorf("expected the oldest 500 of 1000 records to be deleted, the flows table has %d rows", rows)
+	}
+	// the records of number % 10 >= 5 are the oldest
+	if rows := s.count(t, "SELECT COUNT() FROM flows WHERE toUInt64(substring(sourcePodName, 5)) % 10 >= 5"); rows != 0 {
+		t.Errorf("expected the records of the 5 oldest minutes to be deleted, %d are left", rows)
+	}
+}
+
+// TestMonitorAdaptsTTL runs the monitor CronJob once in ttl mode with bounds below the
+// TTL of the migrations: it must clamp the TTL of flows and its views and record the
+// change without materializing it. Run again with the usage above the threshold, it must
+// wait for the change to take effect instead of shortening the TTL. The records older
+// than the new TTL must expire once it is materialized.
+func TestMonitorAdaptsTTL(t *testing.T) {
+	s := startServer(t)
+	s.applySchema(t)
+	monitor := build(t, "../monitor/cronjob_with_log_check/monitor", "monitor")
+
+	// records of 30 minutes ago, kept by the TTL of 1 hour
+	if _, err := s.connect.Exec(`INSERT INTO flows (timeInserted, flowStartSeconds, flowEndSeconds, sourcePodName)
+		SELECT now() - INTERVAL 30 MINUTE, now(), now(), concat('pod-', toString(number)) FROM numbers(1000)`); err != nil {
+		t.Fatal(err)
+	}
+	if rows := s.count(t, "SELECT COUNT() FROM flows_pod_view"); rows == 0 {
+		t.Fatal("the pod view is empty")
+	}
+
+	run(t, t.TempDir(), monitor, "-addr", fmt.Sprintf("127.0.0.1:%d", s.tcpPort), "-mode=ttl", "-min-ttl=1m", "-max-ttl=2m")
+
+	for _, table := range []string{"flows", "flows_pod_view", "flows_node_view", "flows_policy_view"} {
+		var statement string
+		if err := s.connect.QueryRow("SHOW CREATE TABLE " + table).Scan(&statement); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(statement, "TTL timeInserted + toIntervalSecond(120)") {
+			t.Errorf("expected a TTL of 120 seconds on %s:\n%s", table, statement)
+		}
+	}
+	if changes := s.count(t, "SELECT COUNT() FROM flows_ttl_history WHERE ttlSeconds = 120 AND previousTTLSeconds = 3600"); changes != 1 {
+		t.Errorf("expected the TTL change in flows_ttl_history, got %d changes", changes)
+	}
+	if rows := s.count(t, "SELECT COUNT() FROM flows"); rows != 1000 {
+		t.Errorf("expected the TTL not to be materialized, %d of 1000 rows are left", rows)
+	}
+
+	// the records of 30 minutes ago keep the TTL of 1 hour until it is materialized
+	code, out := runExitCode(t, t.TempDir(), monitor, "-addr", fmt.Sprintf("127.0.0.1:%d", s.tcpPort), "-mode=ttl",
+		"-min-ttl=1m", "-max-ttl=2m", "-threshold=0.000001", "-lower-threshold=0")
+	if code != 0 || !strings.Contains(out, "for the TTL 2m0s to take effect") || strings.Contains(out, "Changed TTL") {
+		t.Errorf("expected the monitor to wait for the TTL to take effect, got exit code %d", code)
+	}
+	if waits := s.count(t, "SELECT COUNT() FROM flows_ttl_history WHERE ttlSeconds = 120 AND previousTTLSeconds = 120"); waits != 1 {
+		t.Errorf("expected the wait in flows_ttl_history, got %d", waits)
+	}
+
+	schema := build(t, "../schema", "schema")
+	run(t, t.TempDir(), schema, "-h", "127.0.0.1", "-port", fmt.Sprint(s.tcpPort), "ttl", "set", "-materialize")
+	if !waitFor(time.Minute, func() bool {
+		return s.count(t, "SELECT COUNT() FROM flows") == 0 && s.count(t, "SELECT COUNT() FROM flows_pod_view") == 0
+	}) {
+		t.Error("the records older than the TTL did not expire")
+	}
+}
+
+// TestMonitorEvictsAtMinTTL runs the monitor CronJob once in ttl mode with the TTL at its
+// lower bound and a threshold below the disk usage: it must delete records instead.
+func TestMonitorEvictsAtMinTTL(t *testing.T) {
+	s := startServer(t)
+	s.applySchema(t)
+	monitor := build(t, "../monitor/cronjob_with_log_check/monitor", "monitor")
+
+	if _, err := s.connect.Exec(`INSERT INTO flows (timeInserted, flowStartSeconds, flowEndSeconds, sourcePodName)
+		SELECT now() - toIntervalMinute(number % 10), now(), now(), concat('pod-', toString(number)) FROM numbers(1000)`); err != nil {
+		t.Fatal(err)
+	}
+
+	code, out := runExitCode(t, t.TempDir(), monitor, "-addr", fmt.Sprintf("127.0.0.1:%d", s.tcpPort), "-mode=ttl",
+		"-min-ttl=1h", "-max-ttl=1h", "-threshold=0.000001", "-lower-threshold=0")
+	if code != 0 || !strings.Contains(out, "with the shortest TTL 1h0m0s") || strings.Contains(out, "Changed TTL") {
+		t.Errorf("expected the monitor to keep the TTL and delete records, got exit code %d", code)
+	}
+	if !waitFor(time.Minute, func() bool {
+		return s.count(t, "SELECT COUNT() FROM system.mutations WHERE table = 'flows' AND NOT is_done") == 0
+	}) {
+		t.Fatal("the deletion did not finish")
+	}
+	if rows := s.count(t, "SELECT COUNT() FROM flows"); rows != 500 {
+		t.Errorf("expected the oldest half of the records to be deleted, %d of 1000 are left", rows)
+	}
+}
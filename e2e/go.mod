@@ -0,0 +1,14 @@
+module clickhouse/e2e
+
+go 1.17
+
+require github.com/ClickHouse/clickhouse-go/v2 v2.0.12
+
+require (
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/paulmach/orb v0.4.0 // indirect
+	github.com/pierrec/lz4/v4 v4.1.14 // indirect
+	github.com/shopspring/decimal v1.3.1 // indirect
+	go.opentelemetry.io/otel v1.4.1 // indirect
+	go.opentelemetry.io/otel/trace v1.4.1 // indirect
+)
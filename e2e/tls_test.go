@@ -0,0 +1,90 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// generateCerts generates a CA, server and client certificates with
+// deployment/generate-certs.sh, skipping the test without openssl.
+func generateCerts(t *testing.T) string {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl is not installed")
+	}
+	certs, err := filepath.Abs(filepath.Join(t.TempDir(), "certs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := filepath.Abs("../deployment/generate-certs.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, t.TempDir(), "bash", script, certs)
+	return certs
+}
+
+// TestTLSConnections connects the load generator, with the sql and http writers, the
+// monitor and the counter to the secure ports of a server with a self-signed CA,
+// presenting the client certificate.
+func TestTLSConnections(t *testing.T) {
+	if os.Getenv("CLICKHOUSE_BINARY") == "" {
+		t.Skip("CLICKHOUSE_BINARY is not set")
+	}
+	certs := generateCerts(t)
+	s := startServerWithCerts(t, certs)
+	s.applySchema(t)
+	tlsArgs := []string{
+		"-tls",
+		"-tls-ca", filepath.Join(certs, "ca.pem"),
+		"-tls-cert", filepath.Join(certs, "client.pem"),
+		"-tls-key", filepath.Join(certs, "client-key.pem"),
+	}
+
+	insert := build(t, "../insert", "insert")
+	dir := t.TempDir()
+	run(t, dir, insert, append([]string{"-h", "127.0.0.1", "-port", fmt.Sprint(s.tcpPortSecure),
+		"-r", "100", "-c", "2", "-i", "100ms", "-sample-interval", "0", "-o", dir}, tlsArgs...)...)
+	run(t, dir, insert, append([]string{"-h", "127.0.0.1", "-port", fmt.Sprint(s.tcpPortSecure),
+		"-writer", "http", "-http-port", fmt.Sprint(s.httpsPort),
+		"-r", "100", "-c", "2", "-i", "100ms", "-sample-interval", "0", "-o", dir}, tlsArgs...)...)
+	if rows := s.count(t, "SELECT COUNT() FROM flows"); rows != 400 {
+		t.Errorf("expected 400 rows inserted over TLS, got %d", rows)
+	}
+
+	// the monitor reads the TTL, which is within the bounds and not changed
+	monitor := build(t, "../monitor/cronjob_with_log_check/monitor", "monitor")
+	cmd := exec.Command(monitor, append([]string{"-addr", fmt.Sprintf("127.0.0.1:%d", s.tcpPortSecure),
+		"-mode=ttl", "-min-ttl=1h", "-max-ttl=1h"}, tlsArgs...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "Active TTL: 1h0m0s") {
+		t.Errorf("expected the monitor to read the TTL over TLS, got %v:\n%s", err, out)
+	}
+
+	// the counter samples until it is stopped
+	counter := build(t, "../log", "counter")
+	samples := filepath.Join(dir, "count.csv")
+	cmd = exec.Command(counter, append([]string{"-h", "127.0.0.1", "-port", fmt.Sprint(s.tcpPortSecure),
+		"-interval", "1s", "-part-log=false", "-o", samples}, tlsArgs...)...)
+	cmd.Dir = dir
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	if !waitFor(30*time.Second, func() bool {
+		data, _ := os.ReadFile(samples)
+		// the header and a sample of the 400 rows
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		return len(lines) > 1 && strings.Contains(lines[1], ",400,")
+	}) {
+		data, _ := os.ReadFile(samples)
+		t.Errorf("expected a sample of the flows table over TLS, got:\n%s", data)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// ipfixCollector receives IPFIX messages over UDP and TCP and hands the decoded flow
+// records to the batcher.
+type ipfixCollector struct {
+	decoder   *ipfixDecoder
+	records   chan []interface{}
+	receivers sync.WaitGroup
+
+	mutex   sync.Mutex
+	closers map[io.Closer]struct{}
+	closed  bool
+}
+
+func newIPFIXCollector() *ipfixCollector {
+	return &ipfixCollector{
+		decoder: newIPFIXDecoder(),
+		records: make(chan []interface{}, recordPerCommit),
+		closers: map[io.Closer]struct{}{},
+	}
+}
+
+// track registers closer to be closed on shutdown. It returns false if the collector is
+// already shut down.
+func (c *ipfixCollector) track(closer io.Closer) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		closer.Close()
+		return false
+	}
+	c.closers[closer] = struct{}{}
+	return true
+}
+
+func (c *ipfixCollector) untrack(closer io.Closer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.closers, closer)
+}
+
+func (c *ipfixCollector) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+// shutdown closes the listeners and connections and waits for the receivers to finish.
+func (c *ipfixCollector) shutdown() {
+	c.mutex.Lock()
+	c.closed = true
+	for closer := range c.closers {
+		closer.Close()
+	}
+	c.mutex.Unlock()
+	c.receivers.Wait()
+	close(c.records)
+}
+
+func (c *ipfixCollector) listenUDP(address string) error {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	c.track(conn)
+	c.receivers.Add(1)
+	go func() {
+		defer c.receivers.Done()
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			c.receive("udp/"+addr.String(), buf[:n])
+		}
+	}()
+	return nil
+}
+
+func (c *ipfixCollector) listenTCP(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	c.track(listener)
+	c.receivers.Add(1)
+	go func() {
+		defer c.receivers.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if !c.track(conn) {
+				return
+			}
+			c.receivers.Add(1)
+			go func() {
+				defer c.receivers.Done()
+				defer c.untrack(conn)
+				defer conn.Close()
+				c.receiveStream(conn)
+			}()
+		}
+	}()
+	return nil
+}
+
+// receiveStream reads the IPFIX messages of a TCP connection, framed by the length
+// of their header.
+func (c *ipfixCollector) receiveStream(conn net.Conn) {
+	session := "tcp/" + conn.RemoteAddr().String()
+	in := bufio.NewReader(conn)
+	header := make([]byte, ipfixHeaderLen)
+	for {
+		if _, err := io.ReadFull(in, header); err != nil {
+			if err != io.EOF && !c.isClosed() {
+				klog.Errorf("failed to read from %s: %v", session, err)
+			}
+			return
+		}
+		length := int(binary.BigEndian.Uint16(header[2:]))
+		if length < ipfixHeaderLen {
+			klog.Errorf("invalid IPFIX message length %d from %s", length, session)
+			return
+		}
+		msg := make([]byte, length)
+		copy(msg, header)
+		if _, err := io.ReadFull(in, msg[ipfixHeaderLen:]); err != nil {
+			klog.Errorf("failed to read from %s: %v", session, err)
+			return
+		}
+		c.receive(session, msg)
+	}
+}
+
+func (c *ipfixCollector) receive(session string, msg []byte) {
+	records, err := c.decoder.decode(session, msg)
+	if err != nil {
+		klog.Errorf("failed to decode IPFIX message from %s: %v", session, err)
+	}
+	for _, record := range records {
+		c.records <- record
+	}
+}
+
+// batch commits the received records every recordPerCommit records or every flush
+// interval, whichever comes first, until the collector is shut down.
+func (c *ipfixCollector) batch(pool *writerPool, flushInterval time.Duration) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var rows [][]interface{}
+	index := 0
+	flush := func() {
+		if len(rows) == 0 {
+			return
+		}
+		fmt.Println(index)
+		pool.submit(commitJob{index: index, columns: flowColumns, rows: rows})
+		index++
+		rows = nil
+	}
+	for {
+		select {
+		case record, ok := <-c.records:
+			if !ok {
+				flush()
+				return
+			}
+			rows = append(rows, record)
+			if len(rows) >= recordPerCommit {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// runCollector inserts the flow records received from IPFIX exporters such as the flow
+// aggregator of Antrea until it is interrupted.
+func runCollector(args []string) int {
+	var udpAddress, tcpAddress string
+	var flushInterval time.Duration
+	fs := flag.NewFlagSet("collector", flag.ExitOnError)
+	registerWriterFlags(fs)
+	fs.IntVar(&recordPerCommit, "r", 1000, "max records per commit")
+	fs.StringVar(&udpAddress, "udp", ":4739", "UDP address to receive IPFIX messages on, empty to disable UDP")
+	fs.StringVar(&tcpAddress, "tcp", ":4739", "TCP address to receive IPFIX messages on, empty to disable TCP")
+	fs.DurationVar(&flushInterval, "flush-interval", time.Second, "max time a record waits for its commit")
+	fs.Parse(args)
+	if recordPerCommit < 1 || flushInterval <= 0 {
+		klog.Fatal("the records per commit and the flush interval must be positive")
+	}
+
+	connect := startRun(fs)
+	writer, err := newBatchWriter(connect)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	pool := newWriterPool(writer, workers, maxInFlight)
+	pool.start()
+
+	collector := newIPFIXCollector()
+	if udpAddress != "" {
+		if err := collector.listenUDP(udpAddress); err != nil {
+			klog.Fatal(err)
+		}
+	}
+	if tcpAddress != "" {
+		if err := collector.listenTCP(tcpAddress); err != nil {
+			klog.Fatal(err)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		collector.batch(pool, flushInterval)
+		close(done)
+	}()
+	klog.Infof("Collecting IPFIX messages on udp %q and tcp %q", udpAddress, tcpAddress)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	collector.shutdown()
+	<-done
+	pool.wait()
+
+	logResult()
+	klog.Infof("Collected %d rows in %d commits, availability %f", result.Summary.RowsInserted, result.Summary.Commits, result.Summary.Availability)
+	return 0
+}
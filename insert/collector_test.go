@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCollectorReceiveStream(t *testing.T) {
+	encoder, err := newIPFIXEncoder(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := [][]interface{}{testRecord(0), testRecord(1), testRecord(2)}
+	collector := newIPFIXCollector()
+	client, server := net.Pipe()
+	go func() {
+		defer client.Close()
+		// the stream is framed by the message lengths only
+		for _, msg := range encoder.messages(records, 1500, true) {
+			client.Write(msg)
+		}
+	}()
+	go func() {
+		collector.receiveStream(server)
+		close(collector.records)
+	}()
+	var received [][]interface{}
+	for record := range collector.records {
+		received = append(received, record)
+	}
+	checkRecords(t, records, received)
+}
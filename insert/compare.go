@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// runGroup is a set of runs sharing the same memory size, batch size, interval, client
+// driver and load and writer flags.
+type runGroup struct {
+	key  string
+	runs []*runResult
+}
+
+// runCompare implements the compare subcommand. It loads the given result files,
+// groups them by parameters and compares every run with the oldest run of its group.
+// It returns 1 when a regression is found.
+//
+// example: go run . compare -throughput-tolerance 0.05 results/*.json
+func runCompare(args []string) int {
+	var throughputTolerance, availabilityTolerance float64
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	fs.Float64Var(&throughputTolerance, "throughput-tolerance", 0.05, "relative insert rate drop flagged as a regression")
+	fs.Float64Var(&availabilityTolerance, "availability-tolerance", 0.001, "absolute availability drop flagged as a regression")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s compare [flags] result.json...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var runs []*runResult
+	for _, path := range fs.Args() {
+		run, err := readRunResult(path)
+		if err != nil {
+			klog.Errorf("failed to read %s: %v", path, err)
+			return 2
+		}
+		runs = append(runs, run)
+	}
+
+	regressions := 0
+	for _, group := range groupRuns(runs) {
+		regressions += printGroup(os.Stdout, group, throughputTolerance, availabilityTolerance)
+	}
+	if regressions > 0 {
+		fmt.Printf("%d regression(s) found\n", regressions)
+		return 1
+	}
+	return 0
+}
+
+func readRunResult(path string) (*runResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var run runResult
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// groupFlags are the flags besides -m, -r and the interval that change the load or the
+// insert path, with their default for the results recorded before the flag existed.
+var groupFlags = []struct{ name, defaultValue string }{
+	{"mode", "open"},
+	{"profile", ""},
+	{"workers", "4"},
+	{"max-in-flight", "0"},
+	{"writer", "sql"},
+	{"format", "RowBinary"},
+	{"compression", "none"},
+	{"async", "false"},
+	{"wait-async", "true"},
+}
+
+// groupKey returns the parameters of a run that must be equal for runs to be compared.
+func groupKey(run *runResult) string {
+	key := fmt.Sprintf("memory_size=%sg, batch_size=%s, batch_frequency=%s, driver=%s",
+		run.Flags["m"], run.Flags["r"], runInterval(run), driverModule(run))
+	for _, f := range groupFlags {
+		value, ok := run.Flags[f.name]
+		if !ok {
+			value = f.defaultValue
+		}
+		key += fmt.Sprintf(", %s=%s", f.name, value)
+	}
+	return key
+}
+
+// driverModule returns the module of the client driver of a run without its version.
+func driverModule(run *runResult) string {
+	if run.Driver == "" {
+		return "clickhouse-go v1"
+	}
+	return strings.Fields(run.Driver)[0]
+}
+
+// groupRuns groups runs by groupKey. Groups are sorted by key and runs inside a group
+// by start time.
+func groupRuns(runs []*runResult) []*runGroup {
+	groups := map[string]*runGroup{}
+	for _, run := range runs {
+		key := groupKey(run)
+		if groups[key] == nil {
+			groups[key] = &runGroup{key: key}
+		}
+		groups[key].runs = append(groups[key].runs, run)
+	}
+	sorted := make([]*runGroup, 0, len(groups))
+	for _, group := range groups {
+		sort.Slice(group.runs, func(i, j int) bool { return group.runs[i].StartTime.Before(group.runs[j].StartTime) })
+		sorted = append(sorted, group)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].key < sorted[j].key })
+	return sorted
+}
+
+// runInterval returns the commit interval of a run. Runs older than the -rate flag
+// only recorded the interval as a number of seconds.
+func runInterval(run *runResult) string {
+	batchSize, err := strconv.ParseFloat(run.Flags["r"], 64)
+	if err == nil && run.Summary.TargetRate > 0 {
+		return time.Duration(batchSize / run.Summary.TargetRate * float64(time.Second)).String()
+	}
+	var interval intervalFlag
+	if err := interval.Set(run.Flags["i"]); err != nil {
+		return run.Flags["i"]
+	}
+	return interval.String()
+}
+
+// printGroup prints one table per group and returns the number of regressions in it.
+func printGroup(out io.Writer, group *runGroup, throughputTolerance, availabilityTolerance float64) int {
+	fmt.Fprintf(out, "%s\n", group.key)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tVERSION\tDRIVER\tCOMMITS\tAVAILABILITY\tDELTA\tINSERT RATE\tDELTA %\tP95 MS\tSTATUS")
+	baseline := group.runs[0].Summary
+	regressions := 0
+	for _, run := range group.runs {
+		s := run.Summary
+		availabilityDelta := s.Availability - baseline.Availability
+		var rateDelta float64
+		if baseline.InsertRate > 0 {
+			rateDelta = (s.InsertRate - baseline.InsertRate) / baseline.InsertRate
+		}
+		var status []string
+		if -availabilityDelta > availabilityTolerance {
+			status = append(status, "availability")
+		}
+		if -rateDelta > throughputTolerance {
+			status = append(status, "throughput")
+		}
+		if len(status) > 0 {
+			regressions++
+			status = []string{"REGRESSION(" + strings.Join(status, ",") + ")"}
+		}
+		driver := run.Driver
+		if driver == "" {
+			driver = "clickhouse-go v1"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.6f\t%+.6f\t%.1f\t%+.1f\t%.1f\t%s\n",
+			run.ID, run.ServerVersion, driver, s.Commits, s.Availability, availabilityDelta,
+			s.InsertRate, rateDelta*100, s.LatencyP95Ms, strings.Join(status, ""))
+	}
+	w.Flush()
+	fmt.Fprintln(out)
+	return regressions
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// compareRun returns a run started at minute with the given flags over defaults of
+// 1000 rows every second.
+func compareRun(id string, minute int, flags map[string]string, summary runSummary) *runResult {
+	run := &runResult{
+		ID:        id,
+		Flags:     map[string]string{"m": "1", "r": "1000", "i": "1"},
+		StartTime: time.Date(2022, 3, 1, 10, minute, 0, 0, time.UTC),
+		Driver:    "github.com/ClickHouse/clickhouse-go/v2 v2.0.12",
+		Summary:   summary,
+	}
+	for name, value := range flags {
+		run.Flags[name] = value
+	}
+	return run
+}
+
+func TestGroupRuns(t *testing.T) {
+	runs := []*runResult{
+		compareRun("sql-2", 2, nil, runSummary{}),
+		compareRun("native", 3, map[string]string{"writer": "native"}, runSummary{}),
+		compareRun("sql-1", 1, map[string]string{"writer": "sql", "workers": "4"}, runSummary{}),
+		compareRun("async", 4, map[string]string{"async": "true"}, runSummary{}),
+		compareRun("closed", 5, map[string]string{"mode": "closed"}, runSummary{}),
+		compareRun("workers", 6, map[string]string{"workers": "8"}, runSummary{}),
+		compareRun("batch", 7, map[string]string{"r": "500"}, runSummary{}),
+		compareRun("v1", 8, nil, runSummary{}),
+	}
+	runs[len(runs)-1].Driver = ""
+	groups := groupRuns(runs)
+	if len(groups) != 7 {
+		t.Fatalf("expected 7 groups, got %d", len(groups))
+	}
+	var sql *runGroup
+	for _, group := range groups {
+		if len(group.runs) > 1 {
+			if sql != nil {
+				t.Fatalf("expected one group of several runs, got %s and %s", sql.key, group.key)
+			}
+			sql = group
+		}
+	}
+	// the flags missing from a run are the defaults
+	if sql == nil || len(sql.runs) != 2 || sql.runs[0].ID != "sql-1" || sql.runs[1].ID != "sql-2" {
+		t.Fatalf("expected the sql runs together sorted by start time, got %+v", groups)
+	}
+	if !strings.Contains(sql.key, "writer=sql") || !strings.Contains(sql.key, "driver=github.com/ClickHouse/clickhouse-go/v2") {
+		t.Errorf("expected the writer and the driver in the key, got %s", sql.key)
+	}
+}
+
+func TestPrintGroupTolerances(t *testing.T) {
+	baseline := runSummary{Commits: 100, Availability: 1, InsertRate: 1000}
+	for _, tc := range []struct {
+		name     string
+		summary  runSummary
+		expected string
+	}{
+		{"same", baseline, ""},
+		{"faster", runSummary{Commits: 100, Availability: 1, InsertRate: 1100}, ""},
+		{"throughput within tolerance", runSummary{Commits: 100, Availability: 1, InsertRate: 960}, ""},
+		{"throughput drop", runSummary{Commits: 100, Availability: 1, InsertRate: 940}, "REGRESSION(throughput)"},
+		{"availability within tolerance", runSummary{Commits: 1000, Availability: 0.9995, InsertRate: 1000}, ""},
+		{"availability drop", runSummary{Commits: 100, Availability: 0.99, InsertRate: 1000}, "REGRESSION(availability)"},
+		{"both", runSummary{Commits: 100, Availability: 0.9, InsertRate: 500}, "REGRESSION(availability,throughput)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			group := &runGroup{key: "test", runs: []*runResult{
+				compareRun("baseline", 0, nil, baseline),
+				compareRun("run", 1, nil, tc.summary),
+			}}
+			var out bytes.Buffer
+			regressions := printGroup(&out, group, 0.05, 0.001)
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			last := lines[len(lines)-1]
+			if tc.expected == "" {
+				if regressions != 0 || strings.Contains(last, "REGRESSION") {
+					t.Errorf("expected no regression, got %d:\n%s", regressions, out.String())
+				}
+			} else if regressions != 1 || !strings.HasSuffix(last, tc.expected) {
+				t.Errorf("expected %s, got %d regressions:\n%s", tc.expected, regressions, out.String())
+			}
+		})
+	}
+}
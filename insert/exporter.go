@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// The max size of an IPFIX message sent over UDP, below the usual MTU.
+const maxUDPMessageLen = 1400
+
+// runExporter sends batches of fake flow records as IPFIX messages to a collector, to
+// test the collector locally.
+func runExporter(args []string) int {
+	var collector, transport string
+	var domain uint
+	var templateRefresh time.Duration
+	fs := flag.NewFlagSet("exporter", flag.ExitOnError)
+	fs.StringVar(&collector, "collector", "127.0.0.1:4739", "address of the IPFIX collector")
+	fs.StringVar(&transport, "transport", "udp", "udp or tcp")
+	fs.IntVar(&recordPerCommit, "r", 1, "records number per export")
+	fs.IntVar(&commitNum, "c", 1, "exports number")
+	insertInterval = time.Second
+	fs.Var((*intervalFlag)(&insertInterval), "i", "export interval in seconds or as a duration, e.g. 250ms")
+	fs.UintVar(&domain, "observation-domain", 1, "observation domain ID of the messages")
+	fs.DurationVar(&templateRefresh, "template-refresh", 10*time.Second, "interval of resending the template over UDP")
+	fs.Parse(args)
+
+	maxLen := maxUDPMessageLen
+	switch transport {
+	case "udp":
+	case "tcp":
+		maxLen = 65535
+	default:
+		klog.Fatalf("unknown transport %q", transport)
+	}
+	conn, err := net.Dial(transport, collector)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	defer conn.Close()
+
+	encoder, err := newIPFIXEncoder(uint32(domain))
+	if err != nil {
+		klog.Fatal(err)
+	}
+	var templateSent time.Time
+	sent := 0
+	for i := 0; i < commitNum; i++ {
+		records := make([][]interface{}, recordPerCommit)
+		for j := range records {
+			records[j] = fakeRecord()
+		}
+		withTemplate := templateSent.IsZero() || transport == "udp" && time.Since(templateSent) >= templateRefresh
+		if withTemplate {
+			templateSent = time.Now()
+		}
+		for _, msg := range encoder.messages(records, maxLen, withTemplate) {
+			if _, err := conn.Write(msg); err != nil {
+				klog.Errorf("failed to send IPFIX message: %v", err)
+				continue
+			}
+		}
+		sent += len(records)
+		fmt.Println(i)
+		time.Sleep(insertInterval)
+	}
+	klog.Infof("Exported %d records to %s over %s", sent, collector, transport)
+	return 0
+}
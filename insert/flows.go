@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+)
+
+// flowColumn is a column of the flows table written by the load generator.
+type flowColumn struct {
+	name string
+	typ  string
+}
+
+// flowColumns are the columns of the flows table in the order of fakeRecord,
+// see schema/migrations/single.
+var flowColumns = []flowColumn{
+	{"timeInserted", "DateTime"},
+	{"flowStartSeconds", "DateTime"},
+	{"flowEndSeconds", "DateTime"},
+	{"flowEndSecondsFromSourceNode", "DateTime"},
+	{"flowEndSecondsFromDestinationNode", "DateTime"},
+	{"flowEndReason", "UInt8"},
+	{"sourceIP", "String"},
+	{"destinationIP", "String"},
+	{"sourceTransportPort", "UInt16"},
+	{"destinationTransportPort", "UInt16"},
+	{"protocolIdentifier", "UInt8"},
+	{"packetTotalCount", "UInt64"},
+	{"octetTotalCount", "UInt64"},
+	{"packetDeltaCount", "UInt64"},
+	{"octetDeltaCount", "UInt64"},
+	{"reversePacketTotalCount", "UInt64"},
+	{"reverseOctetTotalCount", "UInt64"},
+	{"reversePacketDeltaCount", "UInt64"},
+	{"reverseOctetDeltaCount", "UInt64"},
+	{"sourcePodName", "String"},
+	{"sourcePodNamespace", "String"},
+	{"sourceNodeName", "String"},
+	{"destinationPodName", "String"},
+	{"destinationPodNamespace", "String"},
+	{"destinationNodeName", "String"},
+	{"destinationClusterIP", "String"},
+	{"destinationServicePort", "UInt16"},
+	{"destinationServicePortName", "String"},
+	{"ingressNetworkPolicyName", "String"},
+	{"ingressNetworkPolicyNamespace", "String"},
+	{"ingressNetworkPolicyRuleName", "String"},
+	{"ingressNetworkPolicyRuleAction", "UInt8"},
+	{"ingressNetworkPolicyType", "UInt8"},
+	{"egressNetworkPolicyName", "String"},
+	{"egressNetworkPolicyNamespace", "String"},
+	{"egressNetworkPolicyRuleName", "String"},
+	{"egressNetworkPolicyRuleAction", "UInt8"},
+	{"egressNetworkPolicyType", "UInt8"},
+	{"tcpState", "String"},
+	{"flowType", "UInt8"},
+	{"sourcePodLabels", "String"},
+	{"destinationPodLabels", "String"},
+	{"throughput", "UInt64"},
+	{"reverseThroughput", "UInt64"},
+	{"throughputFromSourceNode", "UInt64"},
+	{"throughputFromDestinationNode", "UInt64"},
+	{"reverseThroughputFromSourceNode", "UInt64"},
+	{"reverseThroughputFromDestinationNode", "UInt64"},
+}
+
+// columnNames returns the comma separated names of the columns.
+func columnNames(columns []flowColumn) string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+	return strings.Join(names, ",")
+}
+
+// insertQuery returns the INSERT statement for the columns without its VALUES or FORMAT clause.
+func insertQuery(columns []flowColumn) string {
+	return "INSERT INTO flows (" + columnNames(columns) + ")"
+}
@@ -3,25 +3,34 @@ module clickhouse
 go 1.17
 
 require (
-	github.com/ClickHouse/clickhouse-go v1.5.1
+	clickhouse/common v0.0.0
+	github.com/ClickHouse/clickhouse-go/v2 v2.0.12
 	github.com/google/uuid v1.3.0
+	github.com/klauspost/compress v1.15.9
+	gonum.org/v1/plot v0.10.0
+	gopkg.in/yaml.v2 v2.4.0
 	k8s.io/klog/v2 v2.30.0
 )
 
 require (
 	gioui.org v0.0.0-20210308172011-57750fc8a0a6 // indirect
 	github.com/ajstarks/svgo v0.0.0-20210923152817-c3b6e2f0c527 // indirect
-	github.com/cloudflare/golz4 v0.0.0-20150217214814-ef862a3cdc58 // indirect
 	github.com/fogleman/gg v1.3.0 // indirect
 	github.com/go-fonts/liberation v0.2.0 // indirect
 	github.com/go-latex/latex v0.0.0-20210823091927-c0d11ff05a81 // indirect
-	github.com/go-logr/logr v1.2.0 // indirect
+	github.com/go-logr/logr v1.2.2 // indirect
 	github.com/go-pdf/fpdf v0.5.0 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
+	github.com/paulmach/orb v0.4.0 // indirect
+	github.com/pierrec/lz4/v4 v4.1.14 // indirect
+	github.com/shopspring/decimal v1.3.1 // indirect
+	go.opentelemetry.io/otel v1.4.1 // indirect
+	go.opentelemetry.io/otel/trace v1.4.1 // indirect
 	golang.org/x/exp v0.0.0-20191002040644-a1355ae1e2c3 // indirect
 	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d // indirect
-	golang.org/x/sys v0.0.0-20210304124612-50617c2ba197 // indirect
+	golang.org/x/sys v0.0.0-20211110154304-99a53858aa08 // indirect
 	golang.org/x/text v0.3.6 // indirect
-	gonum.org/v1/plot v0.10.0 // indirect
 	rsc.io/pdf v0.1.1 // indirect
 )
+
+replace clickhouse/common => ../common
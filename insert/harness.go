@@ -0,0 +1,282 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"clickhouse/common/storage"
+)
+
+// evictionWindow is the time between the monitor issuing a deletion and the mutation
+// being done. End is zero if the mutation was still running when the run ended.
+type evictionWindow struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Usage       float64   `json:"usage"`
+	RowsDeleted uint64    `json:"rowsDeleted"`
+	Mutation    string    `json:"mutation"`
+}
+
+// harnessReport describes how the storage monitor kept up with the inserts of a run.
+type harnessReport struct {
+	Threshold float64 `json:"threshold"`
+	// The usages are those of the disk with the highest usage, the one the monitor
+	// compares to its threshold, sampled by the monitor. FinalUsage is sampled at the
+	// end of the run.
+	MaxUsage                    float64          `json:"maxUsage"`
+	FinalUsage                  float64          `json:"finalUsage"`
+	TimeAboveThresholdSec       float64          `json:"timeAboveThresholdSec"`
+	Evictions                   int              `json:"evictions"`
+	MonitorErrors               int              `json:"monitorErrors"`
+	CommitsDuringEviction       int              `json:"commitsDuringEviction"`
+	AvailabilityDuringEviction  float64          `json:"availabilityDuringEviction"`
+	CommitsOutsideEviction      int              `json:"commitsOutsideEviction"`
+	AvailabilityOutsideEviction float64          `json:"availabilityOutsideEviction"`
+	Windows                     []evictionWindow `json:"windows"`
+}
+
+// storageMonitor runs the eviction policy of the clickhouse monitor CronJob
+// (monitor/cronjob_with_log_check) in-process: every round calls the same
+// storage.MonitorMemory on the flows table. The CronJob schedule is replaced by a
+// ticker, and the rounds skipped after a deletion, which the CronJob reads back from
+// the log of its last job, are counted in memory.
+type storageMonitor struct {
+	store            storage.Store
+	threshold        float64
+	deletePercentage float64
+	skipRoundsNum    int
+
+	mutex    sync.Mutex
+	windows  []evictionWindow
+	usage    []diskUsage
+	errors   int
+	skipping int
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// diskUsage is the usage of the disk with the highest usage at a time.
+type diskUsage struct {
+	time  time.Time
+	usage float64
+}
+
+// monitoredTable is the table the storage monitor evicts records from.
+const monitoredTable = "flows"
+
+func newStorageMonitor(store storage.Store, threshold, deletePercentage float64, skipRoundsNum int) *storageMonitor {
+	return &storageMonitor{
+		store:            store,
+		threshold:        threshold,
+		deletePercentage: deletePercentage,
+		skipRoundsNum:    skipRoundsNum,
+		stopCh:           make(chan struct{}),
+		doneCh:           make(chan struct{}),
+	}
+}
+
+// start runs a monitor round every interval, and every checkInterval checks whether
+// the last deletion is done and samples the usage.
+func (m *storageMonitor) start(interval, checkInterval time.Duration) {
+	go func() {
+		defer close(m.doneCh)
+		roundTicker := time.NewTicker(interval)
+		defer roundTicker.Stop()
+		checkTicker := time.NewTicker(checkInterval)
+		defer checkTicker.Stop()
+		for {
+			select {
+			case <-m.stopCh:
+				return
+			case <-roundTicker.C:
+				m.round()
+			case <-checkTicker.C:
+				m.checkMutation()
+				m.sampleUsage()
+			}
+		}
+	}()
+}
+
+func (m *storageMonitor) stop() {
+	select {
+	case <-m.stopCh:
+	default:
+		close(m.stopCh)
+	}
+	<-m.doneCh
+}
+
+func (m *storageMonitor) round() {
+	if m.skipping > 0 {
+		m.skipping--
+		klog.Infof("Number of rounds to be skipped: %d", m.skipping)
+		return
+	}
+	start := time.Now()
+	eviction, err := storage.MonitorMemory(m.store, monitoredTable, m.threshold, m.deletePercentage)
+	if err != nil {
+		m.fail(err)
+		return
+	}
+	if eviction == nil {
+		return
+	}
+	m.mutex.Lock()
+	m.windows = append(m.windows, evictionWindow{Start: start, Usage: eviction.Usage, RowsDeleted: eviction.Rows, Mutation: eviction.Mutation})
+	m.mutex.Unlock()
+	m.skipping = m.skipRoundsNum
+	klog.Infof("Number of rounds to be skipped: %d", m.skipping)
+}
+
+// checkMutation closes the open eviction window once the mutation of its deletion
+// is done.
+func (m *storageMonitor) checkMutation() {
+	m.mutex.Lock()
+	open := len(m.windows) > 0 && m.windows[len(m.windows)-1].End.IsZero()
+	var mutation string
+	if open {
+		mutation = m.windows[len(m.windows)-1].Mutation
+	}
+	m.mutex.Unlock()
+	if !open {
+		return
+	}
+	done, err := m.store.MutationStatus(monitoredTable, mutation)
+	if err != nil {
+		m.fail(err)
+		return
+	}
+	if done {
+		m.mutex.Lock()
+		m.windows[len(m.windows)-1].End = time.Now()
+		m.mutex.Unlock()
+	}
+}
+
+// sampleUsage records the usage of the store as the monitor computes it. A failure is
+// only logged, the monitor itself did not fail.
+func (m *storageMonitor) sampleUsage() {
+	usage, err := storage.Usage(m.store)
+	if err != nil {
+		klog.Errorf("failed to sample the storage usage: %v", err)
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.usage = append(m.usage, diskUsage{time.Now(), usage})
+}
+
+func (m *storageMonitor) fail(err error) {
+	klog.Error(err)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.errors++
+}
+
+// report stops the monitor and summarizes its usage samples, and the eviction windows
+// against the commits of the run.
+func (m *storageMonitor) report(r *runResult) *harnessReport {
+	m.stop()
+	m.sampleUsage()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	report := &harnessReport{
+		Threshold:     m.threshold,
+		Evictions:     len(m.windows),
+		MonitorErrors: m.errors,
+		Windows:       m.windows,
+	}
+	for i, u := range m.usage {
+		report.FinalUsage = u.usage
+		if u.usage > report.MaxUsage {
+			report.MaxUsage = u.usage
+		}
+		if u.usage > m.threshold && i+1 < len(m.usage) {
+			report.TimeAboveThresholdSec += m.usage[i+1].time.Sub(u.time).Seconds()
+		}
+	}
+	var succeededDuring, succeededOutside int
+	for _, s := range r.Samples {
+		if m.inWindow(s.Start, r.EndTime) {
+			report.CommitsDuringEviction++
+			if s.Success {
+				succeededDuring++
+			}
+		} else {
+			report.CommitsOutsideEviction++
+			if s.Success {
+				succeededOutside++
+			}
+		}
+	}
+	if report.CommitsDuringEviction > 0 {
+		report.AvailabilityDuringEviction = float64(succeededDuring) / float64(report.CommitsDuringEviction)
+	}
+	if report.CommitsOutsideEviction > 0 {
+		report.AvailabilityOutsideEviction = float64(succeededOutside) / float64(report.CommitsOutsideEviction)
+	}
+	return report
+}
+
+// inWindow returns whether t falls into an eviction window. Windows still open are
+// considered to last until the end of the run.
+func (m *storageMonitor) inWindow(t, end time.Time) bool {
+	for _, w := range m.windows {
+		windowEnd := w.End
+		if windowEnd.IsZero() {
+			windowEnd = end
+		}
+		if !t.Before(w.Start) && t.Before(windowEnd) {
+			return true
+		}
+	}
+	return false
+}
+
+// runHarness implements the harness subcommand: it drives inserts like a normal run
+// while the storage monitor runs in-process, and reports whether the monitor kept the
+// usage under its threshold and how inserts behaved during evictions. It fails when
+// the monitor ran into errors or the usage was above the threshold at the end of the run.
+func runHarness(args []string) int {
+	var threshold, deletePercentage float64
+	var skipRoundsNum int
+	var monitorInterval, checkInterval time.Duration
+	fs := flag.NewFlagSet("harness", flag.ExitOnError)
+	registerFlags(fs)
+	fs.Float64Var(&threshold, "threshold", 0.5, "storage usage at which the monitor deletes old records")
+	fs.Float64Var(&deletePercentage, "delete-percentage", 0.5, "share of the records deleted by the monitor")
+	fs.IntVar(&skipRoundsNum, "skip-rounds", 3, "monitor rounds skipped after a deletion")
+	fs.DurationVar(&monitorInterval, "monitor-interval", time.Minute, "interval of the monitor rounds, the schedule of the CronJob")
+	fs.DurationVar(&checkInterval, "mutation-check-interval", time.Second, "interval of checking whether a deletion is done")
+	fs.Parse(args)
+
+	validateLoadFlags()
+	connect := startRun(fs)
+	monitor = newStorageMonitor(storage.NewClickHouseStore(connect), threshold, deletePercentage, skipRoundsNum)
+	monitor.start(monitorInterval, checkInterval)
+
+	SetupCloseHandler()
+	runLoad(connect)
+	logResult()
+
+	report := result.Harness
+	fmt.Printf("max usage: %f (threshold %f), time above threshold: %.0fs\n", report.MaxUsage, report.Threshold, report.TimeAboveThresholdSec)
+	fmt.Printf("evictions: %d, monitor errors: %d\n", report.Evictions, report.MonitorErrors)
+	fmt.Printf("availability during evictions: %f (%d commits), outside evictions: %f (%d commits)\n",
+		report.AvailabilityDuringEviction, report.CommitsDuringEviction, report.AvailabilityOutsideEviction, report.CommitsOutsideEviction)
+	code := 0
+	if report.MonitorErrors > 0 {
+		fmt.Printf("FAIL: the monitor ran into %d errors\n", report.MonitorErrors)
+		code = 1
+	}
+	if report.FinalUsage > report.Threshold {
+		fmt.Printf("FAIL: the usage %f stayed above the threshold %f\n", report.FinalUsage, report.Threshold)
+		code = 1
+	}
+	return code
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"clickhouse/common/storage/storagetest"
+)
+
+func TestHarnessReportUsage(t *testing.T) {
+	store := storagetest.NewMemStore(1000, 0)
+	store.AddTable(monitoredTable, 300, 1, 200)
+	m := newStorageMonitor(store, 0.5, 0.5, 0)
+	m.start(time.Hour, time.Hour)
+	// 0.3, 0.5, 0.7, then 0.4 after deleting 500 rows
+	for i := 0; i < 3; i++ {
+		m.sampleUsage()
+		store.Tick()
+	}
+	store.Tables[monitoredTable].Rows -= 500
+	store.Errors["DiskUsage"] = errors.New("disk usage unavailable")
+	m.sampleUsage()
+	delete(store.Errors, "DiskUsage")
+
+	report := m.report(&runResult{EndTime: time.Now()})
+	if math.Abs(report.MaxUsage-0.7) > 1e-9 || math.Abs(report.FinalUsage-0.4) > 1e-9 {
+		t.Errorf("expected a max usage of 0.7 and a final usage of 0.4, got %f and %f", report.MaxUsage, report.FinalUsage)
+	}
+	if report.MonitorErrors != 0 {
+		t.Errorf("expected a failed usage sample not to count as a monitor error, got %d errors", report.MonitorErrors)
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// IPFIX (RFC 7011) constants.
+const (
+	ipfixVersion      = 10
+	ipfixHeaderLen    = 16
+	ipfixSetHeaderLen = 4
+	ipfixTemplateSet  = 2
+	ipfixOptionsSet   = 3
+	ipfixMinDataSet   = 256
+	ipfixVariableLen  = 65535
+	// antreaEnterpriseID is the private enterprise number of the Antrea information elements.
+	antreaEnterpriseID = 56506
+	// reverseEnterpriseID marks reverse information elements (RFC 5103).
+	reverseEnterpriseID = 29305
+	// flowsTemplateID is the template of the records sent by the exporter.
+	flowsTemplateID = 256
+)
+
+// ipfixField is an information element stored in a column of the flows table.
+type ipfixField struct {
+	column     string
+	id         uint16
+	enterprise uint32
+	length     uint16
+	// address is set for IP addresses stored as strings.
+	address bool
+	// ipv6 is set for the IPv6 variant of an address, which the exporter does not send.
+	ipv6 bool
+}
+
+// ipfixFields are the information elements of the flow aggregator of Antrea, see
+// https://github.com/vmware/go-ipfix/blob/main/pkg/registry/registry_antrea.csv.
+// timeInserted is not exported, the collector sets it.
+var ipfixFields = []ipfixField{
+	{column: "flowStartSeconds", id: 150, length: 4},
+	{column: "flowEndSeconds", id: 151, length: 4},
+	{column: "flowEndSecondsFromSourceNode", id: 151, enterprise: antreaEnterpriseID, length: 4},
+	{column: "flowEndSecondsFromDestinationNode", id: 152, enterprise: antreaEnterpriseID, length: 4},
+	{column: "flowEndReason", id: 136, length: 1},
+	{column: "sourceIP", id: 8, length: 4, address: true},
+	{column: "sourceIP", id: 27, length: 16, address: true, ipv6: true},
+	{column: "destinationIP", id: 12, length: 4, address: true},
+	{column: "destinationIP", id: 28, length: 16, address: true, ipv6: true},
+	{column: "sourceTransportPort", id: 7, length: 2},
+	{column: "destinationTransportPort", id: 11, length: 2},
+	{column: "protocolIdentifier", id: 4, length: 1},
+	{column: "packetTotalCount", id: 86, length: 8},
+	{column: "octetTotalCount", id: 85, length: 8},
+	{column: "packetDeltaCount", id: 2, length: 8},
+	{column: "octetDeltaCount", id: 1, length: 8},
+	{column: "reversePacketTotalCount", id: 86, enterprise: reverseEnterpriseID, length: 8},
+	{column: "reverseOctetTotalCount", id: 85, enterprise: reverseEnterpriseID, length: 8},
+	{column: "reversePacketDeltaCount", id: 2, enterprise: reverseEnterpriseID, length: 8},
+	{column: "reverseOctetDeltaCount", id: 1, enterprise: reverseEnterpriseID, length: 8},
+	{column: "sourcePodName", id: 101, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "sourcePodNamespace", id: 100, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "sourceNodeName", id: 104, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "destinationPodName", id: 103, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "destinationPodNamespace", id: 102, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "destinationNodeName", id: 105, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "destinationClusterIP", id: 106, enterprise: antreaEnterpriseID, length: 4, address: true},
+	{column: "destinationClusterIP", id: 107, enterprise: antreaEnterpriseID, length: 16, address: true, ipv6: true},
+	{column: "destinationServicePort", id: 108, enterprise: antreaEnterpriseID, length: 2},
+	{column: "destinationServicePortName", id: 109, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "ingressNetworkPolicyName", id: 110, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "ingressNetworkPolicyNamespace", id: 111, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "ingressNetworkPolicyRuleName", id: 141, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "ingressNetworkPolicyRuleAction", id: 139, enterprise: antreaEnterpriseID, length: 1},
+	{column: "ingressNetworkPolicyType", id: 115, enterprise: antreaEnterpriseID, length: 1},
+	{column: "egressNetworkPolicyName", id: 112, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "egressNetworkPolicyNamespace", id: 113, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "egressNetworkPolicyRuleName", id: 142, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "egressNetworkPolicyRuleAction", id: 140, enterprise: antreaEnterpriseID, length: 1},
+	{column: "egressNetworkPolicyType", id: 118, enterprise: antreaEnterpriseID, length: 1},
+	{column: "tcpState", id: 136, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "flowType", id: 137, enterprise: antreaEnterpriseID, length: 1},
+	{column: "sourcePodLabels", id: 143, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "destinationPodLabels", id: 144, enterprise: antreaEnterpriseID, length: ipfixVariableLen},
+	{column: "throughput", id: 145, enterprise: antreaEnterpriseID, length: 8},
+	{column: "reverseThroughput", id: 146, enterprise: antreaEnterpriseID, length: 8},
+	{column: "throughputFromSourceNode", id: 147, enterprise: antreaEnterpriseID, length: 8},
+	{column: "throughputFromDestinationNode", id: 148, enterprise: antreaEnterpriseID, length: 8},
+	{column: "reverseThroughputFromSourceNode", id: 149, enterprise: antreaEnterpriseID, length: 8},
+	{column: "reverseThroughputFromDestinationNode", id: 150, enterprise: antreaEnterpriseID, length: 8},
+}
+
+// ipfixFieldKey identifies an information element.
+type ipfixFieldKey struct {
+	enterprise uint32
+	id         uint16
+}
+
+// ipfixFieldByKey indexes ipfixFields.
+var ipfixFieldByKey = func() map[ipfixFieldKey]*ipfixField {
+	fields := map[ipfixFieldKey]*ipfixField{}
+	for i := range ipfixFields {
+		f := &ipfixFields[i]
+		fields[ipfixFieldKey{f.enterprise, f.id}] = f
+	}
+	return fields
+}()
+
+// columnIndex returns the index of the column in flowColumns.
+func columnIndex(name string) (int, error) {
+	for i, column := range flowColumns {
+		if column.name == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("information element of unknown column %s", name)
+}
+
+// templateField is a field specifier of a template. field is nil for information
+// elements the flows table does not store.
+type templateField struct {
+	field  *ipfixField
+	column int
+	length uint16
+}
+
+type templateKey struct {
+	session string
+	domain  uint32
+	id      uint16
+}
+
+// ipfixDecoder decodes IPFIX messages into records of flowColumns, keeping the
+// templates of every session, i.e. TCP connection or UDP exporter address.
+type ipfixDecoder struct {
+	mutex     sync.Mutex
+	templates map[templateKey][]templateField
+}
+
+func newIPFIXDecoder() *ipfixDecoder {
+	return &ipfixDecoder{templates: map[templateKey][]templateField{}}
+}
+
+var errShortIPFIX = errors.New("truncated IPFIX message")
+
+// decode returns the records of the data sets of msg. Data sets of unknown templates
+// are skipped.
+func (d *ipfixDecoder) decode(session string, msg []byte) ([][]interface{}, error) {
+	if len(msg) < ipfixHeaderLen {
+		return nil, errShortIPFIX
+	}
+	if version := binary.BigEndian.Uint16(msg); version != ipfixVersion {
+		return nil, fmt.Errorf("unsupported IPFIX version %d", version)
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if length < ipfixHeaderLen || length > len(msg) {
+		return nil, errShortIPFIX
+	}
+	domain := binary.BigEndian.Uint32(msg[12:])
+	var records [][]interface{}
+	for sets := msg[ipfixHeaderLen:length]; len(sets) > 0; {
+		if len(sets) < ipfixSetHeaderLen {
+			return records, errShortIPFIX
+		}
+		setID := binary.BigEndian.Uint16(sets)
+		setLen := int(binary.BigEndian.Uint16(sets[2:]))
+		if setLen < ipfixSetHeaderLen || setLen > len(sets) {
+			return records, errShortIPFIX
+		}
+		set := sets[ipfixSetHeaderLen:setLen]
+		sets = sets[setLen:]
+		switch {
+		case setID == ipfixTemplateSet:
+			if err := d.readTemplates(templateKey{session: session, domain: domain}, set); err != nil {
+				return records, err
+			}
+		case setID == ipfixOptionsSet:
+			// options carry exporter statistics only
+		case setID >= ipfixMinDataSet:
+			d.mutex.Lock()
+			template, ok := d.templates[templateKey{session, domain, setID}]
+			d.mutex.Unlock()
+			if !ok {
+				continue
+			}
+			decoded, err := decodeDataSet(template, set)
+			records = append(records, decoded...)
+			if err != nil {
+				return records, err
+			}
+		}
+	}
+	return records, nil
+}
+
+func (d *ipfixDecoder) readTemplates(key templateKey, set []byte) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for len(set) >= 4 {
+		key.id = binary.BigEndian.Uint16(set)
+		count := int(binary.BigEndian.Uint16(set[2:]))
+		set = set[4:]
+		if count == 0 {
+			// template withdrawal
+			delete(d.templates, key)
+			continue
+		}
+		template := make([]templateField, count)
+		for i := range template {
+			if len(set) < 4 {
+				return errShortIPFIX
+			}
+			fieldKey := ipfixFieldKey{id: binary.BigEndian.Uint16(set)}
+			template[i].length = binary.BigEndian.Uint16(set[2:])
+			set = set[4:]
+			if fieldKey.id&0x8000 != 0 {
+				if len(set) < 4 {
+					return errShortIPFIX
+				}
+				fieldKey.id &^= 0x8000
+				fieldKey.enterprise = binary.BigEndian.Uint32(set)
+				set = set[4:]
+			}
+			if field, ok := ipfixFieldByKey[fieldKey]; ok {
+				column, err := columnIndex(field.column)
+				if err != nil {
+					return err
+				}
+				template[i].field = field
+				template[i].column = column
+			}
+		}
+		d.templates[key] = template
+	}
+	return nil
+}
+
+// decodeDataSet decodes the records of a data set. Columns without an information
+// element keep the values of an empty record.
+func decodeDataSet(template []templateField, set []byte) ([][]interface{}, error) {
+	minLen := 0
+	for _, f := range template {
+		if f.length == ipfixVariableLen {
+			minLen++
+		} else {
+			minLen += int(f.length)
+		}
+	}
+	var records [][]interface{}
+	now := time.Now()
+	// the set may end with padding shorter than a record
+	for len(set) > 0 && len(set) >= minLen {
+		record := emptyRecord(now)
+		for _, f := range template {
+			length := int(f.length)
+			if f.length == ipfixVariableLen {
+				if len(set) < 1 {
+					return records, errShortIPFIX
+				}
+				length, set = int(set[0]), set[1:]
+				if length == 255 {
+					if len(set) < 2 {
+						return records, errShortIPFIX
+					}
+					length, set = int(binary.BigEndian.Uint16(set)), set[2:]
+				}
+			}
+			if len(set) < length {
+				return records, errShortIPFIX
+			}
+			if f.field != nil {
+				record[f.column] = decodeValue(f.field, flowColumns[f.column].typ, set[:length])
+			}
+			set = set[length:]
+		}
+		records = append(records, record)
+		if minLen == 0 {
+			break
+		}
+	}
+	return records, nil
+}
+
+// emptyRecord returns a record of flowColumns holding zero values, inserted at now.
+func emptyRecord(now time.Time) []interface{} {
+	record := make([]interface{}, len(flowColumns))
+	for i, column := range flowColumns {
+		switch column.typ {
+		case "DateTime":
+			record[i] = time.Unix(0, 0)
+		case "UInt8":
+			record[i] = uint8(0)
+		case "UInt16":
+			record[i] = uint16(0)
+		case "UInt64":
+			record[i] = uint64(0)
+		default:
+			record[i] = ""
+		}
+	}
+	record[0] = now
+	return record
+}
+
+func decodeValue(field *ipfixField, typ string, value []byte) interface{} {
+	if field.address {
+		return net.IP(value).String()
+	}
+	var unsigned uint64
+	for _, b := range value {
+		unsigned = unsigned<<8 | uint64(b)
+	}
+	switch typ {
+	case "DateTime":
+		return time.Unix(int64(unsigned), 0)
+	case "UInt8":
+		return uint8(unsigned)
+	case "UInt16":
+		return uint16(unsigned)
+	case "UInt64":
+		return unsigned
+	default:
+		return string(value)
+	}
+}
+
+// ipfixEncoder builds IPFIX messages of flow records with the template of ipfixFields.
+type ipfixEncoder struct {
+	domain   uint32
+	sequence uint32
+	fields   []ipfixField
+	columns  []int
+}
+
+func newIPFIXEncoder(domain uint32) (*ipfixEncoder, error) {
+	e := &ipfixEncoder{domain: domain}
+	for _, f := range ipfixFields {
+		if !f.ipv6 {
+			column, err := columnIndex(f.column)
+			if err != nil {
+				return nil, err
+			}
+			e.fields = append(e.fields, f)
+			e.columns = append(e.columns, column)
+		}
+	}
+	return e, nil
+}
+
+// templateSet returns the template set announcing the fields of the records.
+func (e *ipfixEncoder) templateSet() []byte {
+	set := make([]byte, ipfixSetHeaderLen+4, 256)
+	binary.BigEndian.PutUint16(set, ipfixTemplateSet)
+	binary.BigEndian.PutUint16(set[4:], flowsTemplateID)
+	binary.BigEndian.PutUint16(set[6:], uint16(len(e.fields)))
+	var buf [8]byte
+	for _, f := range e.fields {
+		id := f.id
+		if f.enterprise != 0 {
+			id |= 0x8000
+		}
+		binary.BigEndian.PutUint16(buf[:], id)
+		binary.BigEndian.PutUint16(buf[2:], f.length)
+		set = append(set, buf[:4]...)
+		if f.enterprise != 0 {
+			binary.BigEndian.PutUint32(buf[:], f.enterprise)
+			set = append(set, buf[:4]...)
+		}
+	}
+	binary.BigEndian.PutUint16(set[2:], uint16(len(set)))
+	return set
+}
+
+// encodeRecord appends the data record of a record of flowColumns to buf.
+func (e *ipfixEncoder) encodeRecord(buf []byte, record []interface{}) []byte {
+	var scratch [8]byte
+	for i, f := range e.fields {
+		switch v := record[e.columns[i]].(type) {
+		case string:
+			if f.address {
+				ip := net.ParseIP(v).To4()
+				if ip == nil {
+					ip = net.IPv4zero.To4()
+				}
+				buf = append(buf, ip...)
+				continue
+			}
+			if len(v) < 255 {
+				buf = append(buf, byte(len(v)))
+			} else {
+				if len(v) > 65535 {
+					v = v[:65535]
+				}
+				binary.BigEndian.PutUint16(scratch[:], uint16(len(v)))
+				buf = append(buf, 255, scratch[0], scratch[1])
+			}
+			buf = append(buf, v...)
+		case time.Time:
+			binary.BigEndian.PutUint32(scratch[:], uint32(v.Unix()))
+			buf = append(buf, scratch[:4]...)
+		case uint8:
+			buf = append(buf, v)
+		case uint16:
+			binary.BigEndian.PutUint16(scratch[:], v)
+			buf = append(buf, scratch[:2]...)
+		case uint64:
+			binary.BigEndian.PutUint64(scratch[:], v)
+			buf = append(buf, scratch[:8]...)
+		}
+	}
+	return buf
+}
+
+// messages returns the records as IPFIX messages of at most maxLen bytes, the first one
+// starting with the template set if withTemplate is set.
+func (e *ipfixEncoder) messages(records [][]interface{}, maxLen int, withTemplate bool) [][]byte {
+	var messages [][]byte
+	var msg []byte
+	dataSet := -1
+	var sequence uint32
+	for i := 0; i < len(records); {
+		if msg == nil {
+			sequence = e.sequence
+			msg = make([]byte, ipfixHeaderLen, maxLen)
+			if withTemplate {
+				msg = append(msg, e.templateSet()...)
+				withTemplate = false
+			}
+			dataSet = len(msg)
+			msg = append(msg, 0, 0, 0, 0)
+		}
+		before := len(msg)
+		msg = e.encodeRecord(msg, records[i])
+		if len(msg) > maxLen && before > dataSet+ipfixSetHeaderLen {
+			// the record does not fit, send it with the next message
+			msg = msg[:before]
+			messages = append(messages, e.finish(msg, dataSet, sequence))
+			msg = nil
+			continue
+		}
+		e.sequence++
+		i++
+	}
+	if msg != nil {
+		messages = append(messages, e.finish(msg, dataSet, sequence))
+	}
+	return messages
+}
+
+// finish fills the headers of the message and of its data set. sequence is the number
+// of records sent before the message.
+func (e *ipfixEncoder) finish(msg []byte, dataSet int, sequence uint32) []byte {
+	binary.BigEndian.PutUint16(msg, ipfixVersion)
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+	binary.BigEndian.PutUint32(msg[4:], uint32(time.Now().Unix()))
+	binary.BigEndian.PutUint32(msg[8:], sequence)
+	binary.BigEndian.PutUint32(msg[12:], e.domain)
+	binary.BigEndian.PutUint16(msg[dataSet:], flowsTemplateID)
+	binary.BigEndian.PutUint16(msg[dataSet+2:], uint16(len(msg)-dataSet))
+	return msg
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addressColumns are the columns stored from IP address information elements.
+func addressColumns() map[string]bool {
+	columns := map[string]bool{}
+	for _, f := range ipfixFields {
+		if f.address {
+			columns[f.column] = true
+		}
+	}
+	return columns
+}
+
+// testRecord returns a record of flowColumns with a distinct value in every column.
+func testRecord(n int) []interface{} {
+	addresses := addressColumns()
+	record := make([]interface{}, len(flowColumns))
+	for i, column := range flowColumns {
+		switch {
+		case column.typ == "DateTime":
+			record[i] = time.Unix(int64(1646128800+100*n+i), 0)
+		case column.typ == "UInt8":
+			record[i] = uint8(n + i)
+		case column.typ == "UInt16":
+			record[i] = uint16(1000*n + i)
+		case column.typ == "UInt64":
+			record[i] = uint64(1<<40 + 1000*n + i)
+		case addresses[column.name]:
+			record[i] = fmt.Sprintf("10.%d.0.%d", n, i)
+		case column.name == "sourcePodLabels":
+			// longer than 254 bytes, encoded with a 3-byte length
+			record[i] = fmt.Sprintf(`{"app":"%s"}`, strings.Repeat("a", 300+n))
+		default:
+			record[i] = fmt.Sprintf("%s-%d", column.name, n)
+		}
+	}
+	return record
+}
+
+// decodeAll decodes messages of a session and fails on errors.
+func decodeAll(t *testing.T, decoder *ipfixDecoder, session string, messages [][]byte) [][]interface{} {
+	t.Helper()
+	var records [][]interface{}
+	for _, msg := range messages {
+		decoded, err := decoder.decode(session, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, decoded...)
+	}
+	return records
+}
+
+// checkRecords compares decoded records with the encoded ones, timeInserted being set
+// by the decoder.
+func checkRecords(t *testing.T, expected, records [][]interface{}) {
+	t.Helper()
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(records))
+	}
+	for i, record := range records {
+		for c := 1; c < len(flowColumns); c++ {
+			if !reflect.DeepEqual(record[c], expected[i][c]) {
+				t.Errorf("record %d, column %s: expected %#v, got %#v", i, flowColumns[c].name, expected[i][c], record[c])
+			}
+		}
+	}
+}
+
+func TestIPFIXFieldsCoverFlowColumns(t *testing.T) {
+	covered := map[string]bool{}
+	for _, f := range ipfixFields {
+		if _, err := columnIndex(f.column); err != nil {
+			t.Errorf("information element %d/%d: %v", f.enterprise, f.id, err)
+		}
+		covered[f.column] = true
+	}
+	// timeInserted is set by the collector
+	for _, column := range flowColumns[1:] {
+		if !covered[column.name] {
+			t.Errorf("column %s has no information element", column.name)
+		}
+	}
+	if _, err := columnIndex("trusted"); err == nil {
+		t.Error("expected an error for a column outside of flowColumns")
+	}
+}
+
+func TestIPFIXRoundTrip(t *testing.T) {
+	encoder, err := newIPFIXEncoder(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([][]interface{}, 10)
+	for i := range records {
+		records[i] = testRecord(i)
+	}
+	// small messages split the records
+	messages := encoder.messages(records, 1500, true)
+	if len(messages) < 2 {
+		t.Fatalf("expected the records to be split into several messages, got %d", len(messages))
+	}
+	decoder := newIPFIXDecoder()
+	checkRecords(t, records, decodeAll(t, decoder, "tcp/exporter", messages))
+
+	t.Run("template reuse", func(t *testing.T) {
+		// later messages of the session rely on the template received before
+		checkRecords(t, records, decodeAll(t, decoder, "tcp/exporter", encoder.messages(records, 1500, false)))
+	})
+	t.Run("unknown template", func(t *testing.T) {
+		// data sets of another session without template are skipped
+		if decoded := decodeAll(t, decoder, "tcp/other", encoder.messages(records, 1500, false)); len(decoded) != 0 {
+			t.Errorf("expected no records without template, got %d", len(decoded))
+		}
+	})
+	t.Run("template withdrawal", func(t *testing.T) {
+		withdrawal := make([]byte, ipfixHeaderLen, ipfixHeaderLen+8)
+		binary.BigEndian.PutUint16(withdrawal, ipfixVersion)
+		binary.BigEndian.PutUint32(withdrawal[12:], 1)
+		withdrawal = append(withdrawal, 0, ipfixTemplateSet, 0, 8, byte(flowsTemplateID>>8), byte(flowsTemplateID&0xff), 0, 0)
+		binary.BigEndian.PutUint16(withdrawal[2:], uint16(len(withdrawal)))
+		decodeAll(t, decoder, "tcp/exporter", [][]byte{withdrawal})
+		if decoded := decodeAll(t, decoder, "tcp/exporter", encoder.messages(records, 1500, false)); len(decoded) != 0 {
+			t.Errorf("expected no records after the template withdrawal, got %d", len(decoded))
+		}
+	})
+}
+
+func TestIPFIXDecodeMalformed(t *testing.T) {
+	encoder, err := newIPFIXEncoder(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := encoder.messages([][]interface{}{testRecord(0)}, 65535, true)[0]
+	dataSet := ipfixHeaderLen + int(binary.BigEndian.Uint16(msg[ipfixHeaderLen+2:]))
+
+	for _, tc := range []struct {
+		name     string
+		malform  func(msg []byte) []byte
+		expected string
+	}{
+		{
+			name:     "short header",
+			malform:  func(msg []byte) []byte { return msg[:ipfixHeaderLen-1] },
+			expected: errShortIPFIX.Error(),
+		},
+		{
+			name: "unsupported version",
+			malform: func(msg []byte) []byte {
+				binary.BigEndian.PutUint16(msg, 9)
+				return msg
+			},
+			expected: "unsupported IPFIX version 9",
+		},
+		{
+			name:     "message shorter than its length",
+			malform:  func(msg []byte) []byte { return msg[:len(msg)-3] },
+			expected: errShortIPFIX.Error(),
+		},
+		{
+			name: "set longer than the message",
+			malform: func(msg []byte) []byte {
+				binary.BigEndian.PutUint16(msg[dataSet+2:], uint16(len(msg)))
+				return msg
+			},
+			expected: errShortIPFIX.Error(),
+		},
+		{
+			name: "truncated record",
+			malform: func(msg []byte) []byte {
+				// the record loses its last bytes, the lengths of the message and the
+				// set are consistent
+				msg = msg[:len(msg)-5]
+				binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+				binary.BigEndian.PutUint16(msg[dataSet+2:], uint16(len(msg)-dataSet))
+				return msg
+			},
+			expected: errShortIPFIX.Error(),
+		},
+		{
+			name: "truncated template",
+			malform: func(msg []byte) []byte {
+				// the template set ends in the middle of a field specifier
+				setLen := 4 + 4 + 4*3 + 2
+				binary.BigEndian.PutUint16(msg[ipfixHeaderLen+2:], uint16(setLen))
+				msg = msg[:ipfixHeaderLen+setLen]
+				binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+				return msg
+			},
+			expected: errShortIPFIX.Error(),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			malformed := tc.malform(append([]byte{}, msg...))
+			_, err := newIPFIXDecoder().decode("udp/exporter", malformed)
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("expected an error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// The number of commits the availability is averaged over in the availability chart.
+const availabilityWindow = 60
+
+// plotResult renders the charts of a run into dir as <id>_<chart>.<format>,
+// format being png or svg.
+func plotResult(r *runResult, dir, format string) error {
+	if format != "png" && format != "svg" {
+		return fmt.Errorf("unsupported plot format %q", format)
+	}
+	charts := []struct {
+		name   string
+		title  string
+		yLabel string
+		line   bool
+		points plotter.XYs
+	}{
+		{"availability", "Availability", fmt.Sprintf("availability (last %d commits)", availabilityWindow), true, availabilityPoints(r)},
+		{"latency", "Commit latency", "latency(ms)", false, latencyPoints(r)},
+		{"rows", "Rows in table", "rows", true, usagePoints(r, func(u usageSample) float64 { return float64(u.Rows) })},
+		{"disk", "Disk usage", "used(MiB)", true, usagePoints(r, func(u usageSample) float64 { return float64(u.DiskUsed) / (1 << 20) })},
+		{"parts", "Active parts", "parts", true, usagePoints(r, func(u usageSample) float64 { return float64(u.ActiveParts) })},
+	}
+	for _, chart := range charts {
+		if len(chart.points) == 0 {
+			continue
+		}
+		p := plot.New()
+		p.Title.Text = chart.title
+		p.X.Label.Text = "time(second)"
+		p.Y.Label.Text = chart.yLabel
+		if chart.line {
+			line, err := plotter.NewLine(chart.points)
+			if err != nil {
+				return err
+			}
+			p.Add(line)
+		} else {
+			scatter, err := plotter.NewScatter(chart.points)
+			if err != nil {
+				return err
+			}
+			p.Add(scatter)
+		}
+		fileName := filepath.Join(dir, fmt.Sprintf("%s_%s.%s", r.ID, chart.name, format))
+		if err := p.Save(8*vg.Inch, 4*vg.Inch, fileName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// availabilityPoints returns the availability over a sliding window of commits.
+func availabilityPoints(r *runResult) plotter.XYs {
+	pts := make(plotter.XYs, len(r.Samples))
+	succeeded := 0
+	for i, s := range r.Samples {
+		if s.Success {
+			succeeded++
+		}
+		window := i + 1
+		if i >= availabilityWindow {
+			if r.Samples[i-availabilityWindow].Success {
+				succeeded--
+			}
+			window = availabilityWindow
+		}
+		pts[i].X = s.Start.Sub(r.StartTime).Seconds()
+		pts[i].Y = float64(succeeded) / float64(window)
+	}
+	return pts
+}
+
+func latencyPoints(r *runResult) plotter.XYs {
+	var pts plotter.XYs
+	for _, s := range r.Samples {
+		if s.Success {
+			pts = append(pts, plotter.XY{X: s.Start.Sub(r.StartTime).Seconds(), Y: s.LatencyMs})
+		}
+	}
+	return pts
+}
+
+func usagePoints(r *runResult, value func(usageSample) float64) plotter.XYs {
+	pts := make(plotter.XYs, len(r.Usage))
+	for i, u := range r.Usage {
+		pts[i].X = u.Time.Sub(r.StartTime).Seconds()
+		pts[i].Y = value(u)
+	}
+	return pts
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// commitJob is a commit handed to a writer. scheduled is the time the commit was due
+// in open-loop mode and is zero in closed-loop mode. Without rows, a batch of fake
+// records is written.
+type commitJob struct {
+	index     int
+	scheduled time.Time
+	rate      float64
+	columns   []flowColumn
+	rows      [][]interface{}
+}
+
+// writerPool runs commits on a fixed number of concurrent writers.
+type writerPool struct {
+	writer      batchWriter
+	workers     int
+	maxInFlight int
+
+	jobs  chan commitJob
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newWriterPool(writer batchWriter, workers, maxInFlight int) *writerPool {
+	return &writerPool{
+		writer:      writer,
+		workers:     workers,
+		maxInFlight: maxInFlight,
+	}
+}
+
+// runOpenLoop sends n commits at the times of the load schedule, independent of how
+// fast ClickHouse completes them. At most maxInFlight commits are queued or running;
+// when the bound is reached the scheduler waits, and the commits due in the meantime
+// are sent late, each recording its delay as lag. With a load profile, commits are
+// sent until the profile ends and n is ignored.
+func (p *writerPool) runOpenLoop(n int, schedule *loadSchedule) {
+	p.start()
+	for i := 0; schedule.profile != nil || i < n; i++ {
+		scheduled, rate, ok := schedule.due(i)
+		if !ok {
+			break
+		}
+		time.Sleep(time.Until(scheduled))
+		fmt.Println(i)
+		p.submit(commitJob{index: i, scheduled: scheduled, rate: rate})
+	}
+	p.wait()
+}
+
+// start starts the writers consuming submitted jobs.
+func (p *writerPool) start() {
+	p.jobs = make(chan commitJob, p.maxInFlight)
+	p.slots = make(chan struct{}, p.maxInFlight)
+	for w := 0; w < p.workers; w++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				writeRecords(p.writer, job)
+				<-p.slots
+			}
+		}()
+	}
+}
+
+// submit hands a job to the writers, waiting while maxInFlight jobs are queued or running.
+func (p *writerPool) submit(job commitJob) {
+	p.slots <- struct{}{}
+	p.jobs <- job
+}
+
+// wait waits for the submitted jobs to finish and stops the writers.
+func (p *writerPool) wait() {
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+// runClosedLoop runs n commits where every writer starts its next commit one interval
+// after its previous commit finished.
+func (p *writerPool) runClosedLoop(n int, interval time.Duration) {
+	next := int64(-1)
+	var wg sync.WaitGroup
+	for w := 0; w < p.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				fmt.Println(i)
+				writeRecords(p.writer, commitJob{index: i})
+				if i+p.workers < n {
+					time.Sleep(interval)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
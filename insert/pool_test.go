@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWriter counts the rows of the batches and blocks on the batches of the commits
+// listed in block.
+type fakeWriter struct {
+	mutex   sync.Mutex
+	batches int
+	block   map[int]time.Duration
+}
+
+func (w *fakeWriter) writeBatch(ctx context.Context, columns []flowColumn, rows [][]interface{}) error {
+	w.mutex.Lock()
+	delay := w.block[w.batches]
+	w.batches++
+	w.mutex.Unlock()
+	time.Sleep(delay)
+	return nil
+}
+
+// runCommits runs the open loop on a fake writer and returns the commit samples.
+func runCommits(t *testing.T, writer *fakeWriter, workers, maxInFlight, batch, n int, schedule *loadSchedule) []commitSample {
+	t.Helper()
+	previous := recordPerCommit
+	recordPerCommit = batch
+	commits = recorder{}
+	defer func() {
+		recordPerCommit = previous
+		commits = recorder{}
+	}()
+	newWriterPool(writer, workers, maxInFlight).runOpenLoop(n, schedule)
+	return commits.snapshot()
+}
+
+func TestLoadScheduleConstantRate(t *testing.T) {
+	start := time.Now()
+	schedule := newLoadSchedule(start, 500, 1000, nil)
+	for _, i := range []int{3, 0, 10, 1} {
+		scheduled, rate, ok := schedule.due(i)
+		if expected := start.Add(time.Duration(i) * 500 * time.Millisecond); !ok || rate != 1000 || !scheduled.Equal(expected) {
+			t.Errorf("commit %d: expected %s at 1000 rows/s, got %s at %f (%t)", i, expected.Sub(start), scheduled.Sub(start), rate, ok)
+		}
+	}
+}
+
+func TestLoadScheduleRampFromZero(t *testing.T) {
+	// 30000 rows over the minute of the ramp, 60 commits of 500 rows
+	profile := &loadProfile{Stages: []loadStage{{Type: "ramp", Duration: time.Minute, From: 0, To: 1000}}}
+	start := time.Now()
+	schedule := newLoadSchedule(start, 500, 0, profile)
+	var last time.Duration
+	n := 0
+	for ; ; n++ {
+		scheduled, _, ok := schedule.due(n)
+		if !ok {
+			break
+		}
+		last = scheduled.Sub(start)
+		if n == 1 {
+			// the rows of the first t seconds are 1000/60 * t^2 / 2
+			if last < 7700*time.Millisecond || last > 7800*time.Millisecond {
+				t.Errorf("expected the second commit after 7.75s, got %s", last)
+			}
+		}
+	}
+	if n < 59 || n > 61 || last > time.Minute {
+		t.Errorf("expected 60 commits during the ramp, got %d, the last one at %s", n, last)
+	}
+}
+
+func TestRunOpenLoopRampFromZero(t *testing.T) {
+	// 5000 rows over the 500ms of the ramp, 10 commits of 500 rows
+	profile := &loadProfile{Stages: []loadStage{{Type: "ramp", Duration: 500 * time.Millisecond, From: 0, To: 20000}}}
+	start := time.Now()
+	samples := runCommits(t, &fakeWriter{}, 4, 4, 500, 0, newLoadSchedule(start, 500, 0, profile))
+	if len(samples) < 9 {
+		t.Fatalf("expected 10 commits during the ramp, got %d", len(samples))
+	}
+	for _, s := range samples {
+		if s.Start.Sub(start) > time.Second {
+			t.Errorf("commit %d started %s after the start of a ramp of 500ms", s.Index, s.Start.Sub(start))
+		}
+	}
+}
+
+func TestRunOpenLoopStallRecordsLag(t *testing.T) {
+	// a commit every 10ms, the first one blocks the only slot for 300ms
+	writer := &fakeWriter{block: map[int]time.Duration{0: 300 * time.Millisecond}}
+	samples := runCommits(t, writer, 1, 1, 1, 20, newLoadSchedule(time.Now(), 1, 100, nil))
+	if len(samples) != 20 {
+		t.Fatalf("expected 20 commits, got %d", len(samples))
+	}
+	// the commits due during the stall are sent late on the original schedule
+	for _, s := range samples[1:10] {
+		if s.LagMs < 150 {
+			t.Errorf("expected commit %d delayed by the stall to record lag, got %.1fms", s.Index, s.LagMs)
+		}
+	}
+}
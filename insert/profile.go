@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// loadStage is one stage of a load profile. Rates are in rows per second.
+//
+//   - ramp:    linear change from "from" to "to"
+//   - step:    "rates" one after another, each for an equal share of the duration
+//   - spike:   "rate", raised to "peak" for "length" at the start of every "every"
+//   - diurnal: sinusoid between "min" and "max" with the given period, starting at "min"
+//   - soak:    constant "rate", usually for a long duration
+type loadStage struct {
+	Type     string        `yaml:"type"`
+	Duration time.Duration `yaml:"duration"`
+	Rate     float64       `yaml:"rate"`
+	From     float64       `yaml:"from"`
+	To       float64       `yaml:"to"`
+	Rates    []float64     `yaml:"rates"`
+	Peak     float64       `yaml:"peak"`
+	Every    time.Duration `yaml:"every"`
+	Length   time.Duration `yaml:"length"`
+	Min      float64       `yaml:"min"`
+	Max      float64       `yaml:"max"`
+	Period   time.Duration `yaml:"period"`
+}
+
+// loadProfile is a sequence of stages replayed by the open-loop scheduler, e.g.
+//
+//	stages:
+//	- type: ramp
+//	  duration: 30m
+//	  from: 500
+//	  to: 4000
+//	- type: spike
+//	  duration: 1h
+//	  rate: 1000
+//	  peak: 6000
+//	  every: 10m
+//	  length: 30s
+type loadProfile struct {
+	Stages []loadStage `yaml:"stages"`
+}
+
+func readLoadProfile(path string) (*loadProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var profile loadProfile
+	if err := yaml.UnmarshalStrict(data, &profile); err != nil {
+		return nil, err
+	}
+	if len(profile.Stages) == 0 {
+		return nil, fmt.Errorf("profile %s has no stages", path)
+	}
+	for i, stage := range profile.Stages {
+		if err := stage.validate(); err != nil {
+			return nil, fmt.Errorf("stage %d: %v", i, err)
+		}
+	}
+	return &profile, nil
+}
+
+// validate checks the fields the stage type requires, so that a stage missing them
+// does not run at a rate of zero.
+func (s *loadStage) validate() error {
+	if s.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	switch s.Type {
+	case "ramp":
+		if s.From < 0 || s.To < 0 || s.From == 0 && s.To == 0 {
+			return fmt.Errorf("ramp stage requires from and to, not negative and not both 0")
+		}
+	case "soak":
+		if s.Rate <= 0 {
+			return fmt.Errorf("soak stage requires a positive rate")
+		}
+	case "step":
+		if len(s.Rates) == 0 {
+			return fmt.Errorf("step stage requires rates")
+		}
+		for _, rate := range s.Rates {
+			if rate < 0 {
+				return fmt.Errorf("step stage rates must not be negative")
+			}
+		}
+	case "spike":
+		if s.Every <= 0 || s.Length <= 0 || s.Length > s.Every {
+			return fmt.Errorf("spike stage requires 0 < length <= every")
+		}
+		if s.Peak <= 0 || s.Rate < 0 {
+			return fmt.Errorf("spike stage requires a positive peak and a rate that is not negative")
+		}
+	case "diurnal":
+		if s.Period <= 0 {
+			return fmt.Errorf("diurnal stage requires a positive period")
+		}
+		if s.Max <= 0 || s.Min < 0 || s.Min > s.Max {
+			return fmt.Errorf("diurnal stage requires 0 <= min <= max and a positive max")
+		}
+	default:
+		return fmt.Errorf("unknown stage type %q", s.Type)
+	}
+	return nil
+}
+
+// rateAt returns the rate of the stage at the given offset from its start.
+func (s *loadStage) rateAt(offset time.Duration) float64 {
+	switch s.Type {
+	case "ramp":
+		return s.From + (s.To-s.From)*offset.Seconds()/s.Duration.Seconds()
+	case "step":
+		step := int(offset * time.Duration(len(s.Rates)) / s.Duration)
+		if step >= len(s.Rates) {
+			step = len(s.Rates) - 1
+		}
+		return s.Rates[step]
+	case "spike":
+		if offset%s.Every < s.Length {
+			return s.Peak
+		}
+		return s.Rate
+	case "diurnal":
+		phase := 2 * math.Pi * offset.Seconds() / s.Period.Seconds()
+		return s.Min + (s.Max-s.Min)*(1-math.Cos(phase))/2
+	default:
+		return s.Rate
+	}
+}
+
+// duration returns the total length of the profile.
+func (p *loadProfile) duration() time.Duration {
+	var total time.Duration
+	for _, stage := range p.Stages {
+		total += stage.Duration
+	}
+	return total
+}
+
+// rateAt returns the target rate at the given time since the start of the profile and
+// false once the profile is over.
+func (p *loadProfile) rateAt(elapsed time.Duration) (float64, bool) {
+	for i := range p.Stages {
+		stage := &p.Stages[i]
+		if elapsed < stage.Duration {
+			return stage.rateAt(elapsed), true
+		}
+		elapsed -= stage.Duration
+	}
+	return 0, false
+}
+
+// averageRate returns the mean target rate over the whole profile.
+func (p *loadProfile) averageRate() float64 {
+	const steps = 1000
+	total := p.duration()
+	var sum float64
+	for i := 0; i < steps; i++ {
+		rate, _ := p.rateAt(total * time.Duration(i) / steps)
+		sum += rate
+	}
+	return sum / steps
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadStageValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		stage loadStage
+		valid bool
+	}{
+		{name: "ramp", stage: loadStage{Type: "ramp", Duration: time.Minute, From: 0, To: 1000}, valid: true},
+		{name: "ramp without from and to", stage: loadStage{Type: "ramp", Duration: time.Minute}},
+		{name: "ramp with a negative rate", stage: loadStage{Type: "ramp", Duration: time.Minute, From: -1, To: 1000}},
+		{name: "soak", stage: loadStage{Type: "soak", Duration: time.Minute, Rate: 1000}, valid: true},
+		{name: "soak without rate", stage: loadStage{Type: "soak", Duration: time.Minute}},
+		{name: "step", stage: loadStage{Type: "step", Duration: time.Minute, Rates: []float64{0, 1000}}, valid: true},
+		{name: "step without rates", stage: loadStage{Type: "step", Duration: time.Minute}},
+		{name: "step with a negative rate", stage: loadStage{Type: "step", Duration: time.Minute, Rates: []float64{-1}}},
+		{name: "spike", stage: loadStage{Type: "spike", Duration: time.Minute, Rate: 100, Peak: 1000, Every: 10 * time.Second, Length: time.Second}, valid: true},
+		{name: "spike without peak", stage: loadStage{Type: "spike", Duration: time.Minute, Rate: 100, Every: 10 * time.Second, Length: time.Second}},
+		{name: "spike longer than its period", stage: loadStage{Type: "spike", Duration: time.Minute, Peak: 1000, Every: time.Second, Length: 10 * time.Second}},
+		{name: "diurnal", stage: loadStage{Type: "diurnal", Duration: time.Hour, Min: 0, Max: 1000, Period: time.Hour}, valid: true},
+		{name: "diurnal without max", stage: loadStage{Type: "diurnal", Duration: time.Hour, Period: time.Hour}},
+		{name: "diurnal with min above max", stage: loadStage{Type: "diurnal", Duration: time.Hour, Min: 2000, Max: 1000, Period: time.Hour}},
+		{name: "without duration", stage: loadStage{Type: "soak", Rate: 1000}},
+		{name: "unknown type", stage: loadStage{Type: "burst", Duration: time.Minute, Rate: 1000}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.stage.validate(); (err == nil) != tc.valid {
+				t.Errorf("expected valid %t, got %v", tc.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// intervalFlag is a duration flag that also accepts a plain number of seconds,
+// so that both "-i 2" and "-i 250ms" work.
+type intervalFlag time.Duration
+
+func (i *intervalFlag) String() string {
+	return time.Duration(*i).String()
+}
+
+func (i *intervalFlag) Set(value string) error {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		*i = intervalFlag(seconds * float64(time.Second))
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid interval %q: %v", value, err)
+	}
+	*i = intervalFlag(d)
+	return nil
+}
+
+// scheduleStep is the step the rate of a load profile is integrated with.
+const scheduleStep = 10 * time.Millisecond
+
+// loadSchedule computes the times commits of batch rows are due: commit i is due once
+// i batches of rows are due at the target rate since start. The due time of a commit
+// does not depend on when the previous commits were sent, so a scheduler stalled by
+// max-in-flight falls behind the schedule and every commit it delays records the delay
+// as lag, rather than the schedule restarting from the end of the stall.
+type loadSchedule struct {
+	start time.Time
+	batch float64
+	// rate is the constant rate without profile, unlimited if not positive.
+	rate    float64
+	profile *loadProfile
+	// rows are the rows due at elapsed, the integral of the rate of the profile.
+	elapsed time.Duration
+	rows    float64
+}
+
+func newLoadSchedule(start time.Time, batch int, rate float64, profile *loadProfile) *loadSchedule {
+	return &loadSchedule{start: start, batch: float64(batch), rate: rate, profile: profile}
+}
+
+// due returns the time commit i is due and the target rate at that time. With a
+// profile, it returns false for the commits due after the end of the profile. At an
+// unlimited rate every commit is due now.
+func (s *loadSchedule) due(i int) (time.Time, float64, bool) {
+	target := float64(i) * s.batch
+	if s.profile == nil {
+		if s.rate <= 0 {
+			return time.Now(), 0, true
+		}
+		return s.start.Add(time.Duration(target / s.rate * float64(time.Second))), s.rate, true
+	}
+	for {
+		rate, ok := s.profile.rateAt(s.elapsed)
+		if !ok {
+			return time.Time{}, 0, false
+		}
+		if s.rows >= target {
+			return s.start.Add(s.elapsed), rate, true
+		}
+		step := scheduleStep
+		if rate > 0 {
+			if remaining := time.Duration((target - s.rows) / rate * float64(time.Second)); remaining < step {
+				step = remaining + 1
+			}
+		}
+		s.rows += rate * step.Seconds()
+		s.elapsed += step
+	}
+}
+
+// targetRate returns the rows per second the run aims for: the average rate of the load
+// profile, the -rate flag if set, otherwise one batch per insertion interval. It returns
+// 0 when the rate is unlimited.
+func targetRate() float64 {
+	if profile != nil {
+		return profile.averageRate()
+	}
+	if insertRate > 0 {
+		return insertRate
+	}
+	if insertInterval <= 0 {
+		return 0
+	}
+	return float64(recordPerCommit) / insertInterval.Seconds()
+}
+
+// commitInterval returns the time between two commits at the target rate.
+func commitInterval() time.Duration {
+	rate := targetRate()
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(recordPerCommit) / rate * float64(time.Second))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// replayBatch is a batch of consecutive records of a dump, due at the offset of its
+// timeInserted from the timeInserted of the first record.
+type replayBatch struct {
+	offset time.Duration
+	rows   [][]interface{}
+}
+
+// replayer splits a dump into batches of records inserted at the same time, optionally
+// shifting every DateTime column so that the first record is inserted now.
+type replayer struct {
+	reader     recordReader
+	maxBatch   int
+	rewrite    bool
+	timeIndex  int
+	first      time.Time
+	shift      time.Duration
+	pending    []interface{}
+	started    bool
+	timeColumn []bool
+}
+
+func newReplayer(reader recordReader, maxBatch int, rewrite bool) *replayer {
+	return &replayer{reader: reader, maxBatch: maxBatch, rewrite: rewrite, timeIndex: -1}
+}
+
+// next returns the next batch of the dump, or io.EOF after the last record.
+func (r *replayer) next() (*replayBatch, error) {
+	if !r.started {
+		record, err := r.reader.next()
+		if err != nil {
+			return nil, err
+		}
+		r.start(record)
+	}
+	if r.pending == nil {
+		return nil, io.EOF
+	}
+	batch := &replayBatch{}
+	if r.timeIndex >= 0 {
+		batch.offset = r.pending[r.timeIndex].(time.Time).Sub(r.first)
+	}
+	for r.pending != nil && len(batch.rows) < r.maxBatch {
+		if r.timeIndex >= 0 && r.pending[r.timeIndex].(time.Time).Sub(r.first) != batch.offset {
+			break
+		}
+		batch.rows = append(batch.rows, r.rewriteTime(r.pending))
+		record, err := r.reader.next()
+		if err == io.EOF {
+			record = nil
+		} else if err != nil {
+			return nil, err
+		}
+		if record != nil && r.timeIndex >= 0 && record[r.timeIndex].(time.Time).Before(r.pending[r.timeIndex].(time.Time)) {
+			return nil, fmt.Errorf("the dump is not sorted by timeInserted: %s follows %s, export it with ORDER BY timeInserted",
+				record[r.timeIndex].(time.Time).UTC().Format(time.RFC3339), r.pending[r.timeIndex].(time.Time).UTC().Format(time.RFC3339))
+		}
+		r.pending = record
+	}
+	return batch, nil
+}
+
+// start initializes the replay from the first record of the dump.
+func (r *replayer) start(record []interface{}) {
+	r.started = true
+	r.pending = record
+	columns := r.reader.columns()
+	r.timeColumn = make([]bool, len(columns))
+	for i, column := range columns {
+		r.timeColumn[i] = column.typ == "DateTime"
+		if column.name == "timeInserted" {
+			r.timeIndex = i
+		}
+	}
+	if r.timeIndex < 0 {
+		klog.Warning("the dump has no timeInserted column, records are replayed as fast as possible")
+		return
+	}
+	r.first = record[r.timeIndex].(time.Time)
+	r.shift = time.Now().Truncate(time.Second).Sub(r.first)
+}
+
+// rewriteTime returns a copy of the record with its DateTime columns shifted, if requested.
+func (r *replayer) rewriteTime(record []interface{}) []interface{} {
+	if !r.rewrite || r.timeIndex < 0 {
+		return record
+	}
+	rewritten := make([]interface{}, len(record))
+	for i, value := range record {
+		if r.timeColumn[i] {
+			value = value.(time.Time).Add(r.shift)
+		}
+		rewritten[i] = value
+	}
+	return rewritten
+}
+
+// runReplay inserts the records of a dump exported with SELECT * FROM flows ORDER BY
+// timeInserted FORMAT ..., committing records inserted at the same time together.
+// Commits are due at the original gaps divided by the speed. The replay stops at the
+// first record of an unsorted dump earlier than the one before it.
+func runReplay(args []string) int {
+	var format, timezone string
+	var speed float64
+	var rewrite bool
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replay [flags] <dump>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	registerWriterFlags(fs)
+	fs.IntVar(&recordPerCommit, "r", 10000, "max records per commit")
+	fs.StringVar(&format, "input-format", "", "format of the dump: CSV, CSVWithNames, JSONEachRow or Native, inferred from the file extension if empty")
+	fs.StringVar(&timezone, "timezone", "UTC", "time zone of the textual DateTime values of the dump, the time zone of the server")
+	fs.Float64Var(&speed, "speed", 1, "replay speed as a multiple of the original speed, 0 replays as fast as possible")
+	fs.BoolVar(&rewrite, "rewrite-time", false, "shift the timestamps so that the first record is inserted now, keeping the gaps between records")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+	if recordPerCommit < 1 {
+		klog.Fatal("at least one record per commit is required")
+	}
+	if speed < 0 {
+		klog.Fatal("the replay speed must not be negative")
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	if format == "" {
+		if format, err = inputFormat(path); err != nil {
+			klog.Fatal(err)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	defer f.Close()
+	reader, err := newRecordReader(f, format, location)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	connect := startRun(fs)
+	writer, err := newBatchWriter(connect)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	SetupCloseHandler()
+
+	pool := newWriterPool(writer, workers, maxInFlight)
+	pool.start()
+	replay := newReplayer(reader, recordPerCommit, rewrite)
+	start := time.Now()
+	var last time.Duration
+	rows := 0
+	var readErr error
+	for i := 0; ; i++ {
+		batch, err := replay.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			klog.Errorf("failed to read %s: %v", path, err)
+			break
+		}
+		job := commitJob{index: i, columns: reader.columns(), rows: batch.rows}
+		if speed > 0 {
+			job.scheduled = start.Add(time.Duration(float64(batch.offset) / speed))
+			time.Sleep(time.Until(job.scheduled))
+		}
+		fmt.Println(i)
+		pool.submit(job)
+		last = batch.offset
+		rows += len(batch.rows)
+	}
+	pool.wait()
+
+	// the target rate is the rate of the dump at the replay speed
+	if speed > 0 && last > 0 {
+		insertRate = float64(rows) / last.Seconds() * speed
+	}
+	logResult()
+	klog.Infof("Replayed %d rows: achieved %.1f rows/s, target %.1f rows/s", result.Summary.RowsInserted, result.Summary.InsertRate, result.Summary.TargetRate)
+	if readErr != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tableColumns are all columns of the flows table in the order of SELECT *, i.e. the
+// columns of a CSV dump without names.
+var tableColumns = append(append([]flowColumn{}, flowColumns...), flowColumn{"trusted", "UInt8"})
+
+// recordReader reads the flow records of a dump one at a time. Every record holds the
+// values of the columns in order, typed like the values of fakeRecord.
+type recordReader interface {
+	columns() []flowColumn
+	// next returns io.EOF after the last record.
+	next() ([]interface{}, error)
+}
+
+// newRecordReader returns the reader of dumps in format: CSV, CSVWithNames, JSONEachRow
+// or Native. Textual DateTime values are parsed in location.
+func newRecordReader(in io.Reader, format string, location *time.Location) (recordReader, error) {
+	switch format {
+	case "CSV":
+		return newCSVReader(in, false, location)
+	case "CSVWithNames":
+		return newCSVReader(in, true, location)
+	case "JSONEachRow":
+		return &jsonReader{decoder: json.NewDecoder(in), location: location}, nil
+	case "Native":
+		return &nativeReader{in: bufio.NewReader(in)}, nil
+	default:
+		return nil, fmt.Errorf("unknown input format %q", format)
+	}
+}
+
+// inputFormat infers the format of a dump from its file extension.
+func inputFormat(path string) (string, error) {
+	switch {
+	case strings.HasSuffix(path, ".csv"):
+		return "CSV", nil
+	case strings.HasSuffix(path, ".json"), strings.HasSuffix(path, ".jsonl"), strings.HasSuffix(path, ".ndjson"):
+		return "JSONEachRow", nil
+	case strings.HasSuffix(path, ".native"), strings.HasSuffix(path, ".bin"):
+		return "Native", nil
+	default:
+		return "", fmt.Errorf("cannot infer the format of %s, set -input-format", path)
+	}
+}
+
+// lookupColumns returns the flows columns with the given names.
+func lookupColumns(names []string) ([]flowColumn, error) {
+	columns := make([]flowColumn, len(names))
+	for i, name := range names {
+		found := false
+		for _, column := range tableColumns {
+			if column.name == name {
+				columns[i] = column
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+	return columns, nil
+}
+
+// parseValue converts the textual value of a column of type typ.
+func parseValue(typ, value string, location *time.Location) (interface{}, error) {
+	switch typ {
+	case "DateTime":
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(seconds, 0), nil
+		}
+		return time.ParseInLocation("2006-01-02 15:04:05", value, location)
+	case "UInt8":
+		v, err := strconv.ParseUint(value, 10, 8)
+		return uint8(v), err
+	case "UInt16":
+		v, err := strconv.ParseUint(value, 10, 16)
+		return uint16(v), err
+	case "UInt32":
+		v, err := strconv.ParseUint(value, 10, 32)
+		return uint32(v), err
+	case "UInt64":
+		return strconv.ParseUint(value, 10, 64)
+	case "String":
+		return value, nil
+	default:
+		return nil, fmt.Errorf("unsupported column type %s", typ)
+	}
+}
+
+type csvReader struct {
+	reader   *csv.Reader
+	cols     []flowColumn
+	location *time.Location
+}
+
+func newCSVReader(in io.Reader, withNames bool, location *time.Location) (*csvReader, error) {
+	r := &csvReader{reader: csv.NewReader(in), cols: tableColumns, location: location}
+	r.reader.ReuseRecord = true
+	if withNames {
+		names, err := r.reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the column names: %v", err)
+		}
+		if r.cols, err = lookupColumns(names); err != nil {
+			return nil, err
+		}
+	}
+	r.reader.FieldsPerRecord = len(r.cols)
+	return r, nil
+}
+
+func (r *csvReader) columns() []flowColumn {
+	return r.cols
+}
+
+func (r *csvReader) next() ([]interface{}, error) {
+	fields, err := r.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	record := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if record[i], err = parseValue(r.cols[i].typ, field, r.location); err != nil {
+			return nil, fmt.Errorf("column %s: %v", r.cols[i].name, err)
+		}
+	}
+	return record, nil
+}
+
+// jsonReader reads JSONEachRow dumps. The columns are the keys of the first object,
+// later objects may list them in any order. 64-bit integers may be quoted, as
+// ClickHouse writes them by default.
+type jsonReader struct {
+	decoder  *json.Decoder
+	cols     []flowColumn
+	index    map[string]int
+	location *time.Location
+}
+
+func (r *jsonReader) columns() []flowColumn {
+	if r.cols == nil {
+		// the columns are known once the first record is read
+		return tableColumns
+	}
+	return r.cols
+}
+
+func (r *jsonReader) next() ([]interface{}, error) {
+	var object map[string]json.RawMessage
+	names, err := r.readObject(&object)
+	if err != nil {
+		return nil, err
+	}
+	if r.cols == nil {
+		if r.cols, err = lookupColumns(names); err != nil {
+			return nil, err
+		}
+		r.index = map[string]int{}
+		for i, name := range names {
+			r.index[name] = i
+		}
+	}
+	if len(object) != len(r.cols) {
+		return nil, fmt.Errorf("expected %d columns, got %d", len(r.cols), len(object))
+	}
+	record := make([]interface{}, len(r.cols))
+	for name, raw := range object {
+		i, ok := r.index[name]
+		if !ok {
+			return nil, fmt.Errorf("unexpected column %q", name)
+		}
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			// unquoted number
+			text = string(raw)
+		}
+		if record[i], err = parseValue(r.cols[i].typ, text, r.location); err != nil {
+			return nil, fmt.Errorf("column %s: %v", name, err)
+		}
+	}
+	return record, nil
+}
+
+// readObject decodes the next object into object and returns its keys in order.
+func (r *jsonReader) readObject(object *map[string]json.RawMessage) ([]string, error) {
+	if _, err := r.decoder.Token(); err != nil {
+		return nil, err
+	}
+	*object = map[string]json.RawMessage{}
+	var names []string
+	for r.decoder.More() {
+		token, err := r.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := token.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a column name, got %v", token)
+		}
+		var raw json.RawMessage
+		if err := r.decoder.Decode(&raw); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		(*object)[name] = raw
+	}
+	if _, err := r.decoder.Token(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// nativeReader reads dumps in the Native format: a sequence of blocks, each holding the
+// number of columns and rows followed by every column with its name, type and values.
+type nativeReader struct {
+	in    *bufio.Reader
+	cols  []flowColumn
+	block [][]interface{}
+	row   int
+}
+
+func (r *nativeReader) columns() []flowColumn {
+	if r.cols == nil {
+		return tableColumns
+	}
+	return r.cols
+}
+
+func (r *nativeReader) next() ([]interface{}, error) {
+	for r.block == nil || r.row == len(r.block) {
+		if err := r.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	r.row++
+	return r.block[r.row-1], nil
+}
+
+// The sizes read from a Native dump are checked before allocating: a block has at most
+// the columns of the flows table, and ClickHouse exports blocks of max_block_size rows,
+// 65536 by default.
+const (
+	maxNativeRows         = 1 << 20
+	maxNativeStringLength = 1 << 20
+)
+
+func (r *nativeReader) readBlock() error {
+	numColumns, err := binary.ReadUvarint(r.in)
+	if err != nil {
+		return err
+	}
+	numRows, err := binary.ReadUvarint(r.in)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if numColumns > uint64(len(tableColumns)) {
+		return fmt.Errorf("invalid Native block: %d columns, the flows table has %d", numColumns, len(tableColumns))
+	}
+	if numRows > maxNativeRows {
+		return fmt.Errorf("invalid Native block: %d rows, at most %d are supported, export the dump with a smaller max_block_size", numRows, maxNativeRows)
+	}
+	block := make([][]interface{}, numRows)
+	for i := range block {
+		block[i] = make([]interface{}, numColumns)
+	}
+	columns := make([]flowColumn, numColumns)
+	for c := range columns {
+		name, err := r.readString()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		typ, err := r.readString()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		// DateTime('UTC') is stored like DateTime
+		if strings.HasPrefix(typ, "DateTime(") {
+			typ = "DateTime"
+		}
+		// the flows table has neither, and their serialization differs from the plain
+		// type: a dump must be exported with low_cardinality_allow_in_native_format=0
+		if strings.HasPrefix(typ, "LowCardinality(") || strings.HasPrefix(typ, "Nullable(") {
+			return fmt.Errorf("column %s: unsupported column type %s, export the dump with low_cardinality_allow_in_native_format=0 and without Nullable columns", name, typ)
+		}
+		columns[c] = flowColumn{name, typ}
+		for _, row := range block {
+			if row[c], err = r.readValue(typ); err != nil {
+				return fmt.Errorf("column %s: %v", name, unexpectedEOF(err))
+			}
+		}
+	}
+	if r.cols == nil {
+		r.cols = columns
+	} else if columnNames(columns) != columnNames(r.cols) {
+		return fmt.Errorf("block columns %s differ from %s", columnNames(columns), columnNames(r.cols))
+	}
+	r.block, r.row = block, 0
+	return nil
+}
+
+func (r *nativeReader) readString() (string, error) {
+	n, err := binary.ReadUvarint(r.in)
+	if err != nil {
+		return "", err
+	}
+	if n > maxNativeStringLength {
+		return "", fmt.Errorf("invalid Native string of %d bytes", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r.in, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (r *nativeReader) readValue(typ string) (interface{}, error) {
+	var buf [8]byte
+	switch typ {
+	case "String":
+		return r.readString()
+	case "UInt8":
+		return r.in.ReadByte()
+	case "UInt16":
+		_, err := io.ReadFull(r.in, buf[:2])
+		return binary.LittleEndian.Uint16(buf[:2]), err
+	case "UInt32":
+		_, err := io.ReadFull(r.in, buf[:4])
+		return binary.LittleEndian.Uint32(buf[:4]), err
+	case "UInt64":
+		_, err := io.ReadFull(r.in, buf[:8])
+		return binary.LittleEndian.Uint64(buf[:8]), err
+	case "DateTime":
+		_, err := io.ReadFull(r.in, buf[:4])
+		return time.Unix(int64(binary.LittleEndian.Uint32(buf[:4])), 0), err
+	default:
+		return nil, fmt.Errorf("unsupported column type %s", typ)
+	}
+}
+
+// unexpectedEOF reports a dump ending in the middle of a block.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
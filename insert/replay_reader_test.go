@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	fixtureTime1 = time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	fixtureTime2 = time.Date(2022, 3, 1, 10, 0, 1, 0, time.UTC)
+)
+
+// readAll returns the columns and records of a dump.
+func readAll(t *testing.T, in io.Reader, format string) ([]flowColumn, [][]interface{}, error) {
+	t.Helper()
+	reader, err := newRecordReader(in, format, time.UTC)
+	if err != nil {
+		return nil, nil, err
+	}
+	var records [][]interface{}
+	for {
+		record, err := reader.next()
+		if err == io.EOF {
+			return reader.columns(), records, nil
+		}
+		if err != nil {
+			return reader.columns(), records, err
+		}
+		records = append(records, record)
+	}
+}
+
+// nativeColumn is a column of a Native block written by nativeBlock.
+type nativeColumn struct {
+	name, typ string
+	// values are written as is: uint8, uint16, uint32, uint64, string or a time
+	// written as DateTime
+	values []interface{}
+}
+
+// nativeBlock encodes a block of the Native format.
+func nativeBlock(rows int, columns ...nativeColumn) []byte {
+	var buf bytes.Buffer
+	writeUvarint := func(v uint64) {
+		var b [binary.MaxVarintLen64]byte
+		buf.Write(b[:binary.PutUvarint(b[:], v)])
+	}
+	writeString := func(s string) {
+		writeUvarint(uint64(len(s)))
+		buf.WriteString(s)
+	}
+	writeUvarint(uint64(len(columns)))
+	writeUvarint(uint64(rows))
+	for _, column := range columns {
+		writeString(column.name)
+		writeString(column.typ)
+		for _, value := range column.values {
+			switch v := value.(type) {
+			case string:
+				writeString(v)
+			case time.Time:
+				binary.Write(&buf, binary.LittleEndian, uint32(v.Unix()))
+			default:
+				binary.Write(&buf, binary.LittleEndian, v)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestRecordReaders(t *testing.T) {
+	expectedColumns := []flowColumn{{"timeInserted", "DateTime"}, {"sourceIP", "String"}, {"sourceTransportPort", "UInt16"}, {"octetTotalCount", "UInt64"}}
+	expectedRecords := [][]interface{}{
+		{fixtureTime1, "10.10.0.1", uint16(41000), uint64(1500)},
+		{fixtureTime2, "10.10.0.2", uint16(41001), uint64(18446744073709551615)},
+	}
+	native := append(
+		nativeBlock(1,
+			nativeColumn{"timeInserted", "DateTime('UTC')", []interface{}{fixtureTime1}},
+			nativeColumn{"sourceIP", "String", []interface{}{"10.10.0.1"}},
+			nativeColumn{"sourceTransportPort", "UInt16", []interface{}{uint16(41000)}},
+			nativeColumn{"octetTotalCount", "UInt64", []interface{}{uint64(1500)}}),
+		nativeBlock(1,
+			nativeColumn{"timeInserted", "DateTime", []interface{}{fixtureTime2}},
+			nativeColumn{"sourceIP", "String", []interface{}{"10.10.0.2"}},
+			nativeColumn{"sourceTransportPort", "UInt16", []interface{}{uint16(41001)}},
+			nativeColumn{"octetTotalCount", "UInt64", []interface{}{uint64(18446744073709551615)}})...)
+
+	for _, tc := range []struct {
+		name   string
+		format string
+		dump   string
+	}{
+		{
+			name:   "CSVWithNames",
+			format: "CSVWithNames",
+			dump: "timeInserted,sourceIP,sourceTransportPort,octetTotalCount\n" +
+				"2022-03-01 10:00:00,10.10.0.1,41000,1500\n" +
+				"2022-03-01 10:00:01,\"10.10.0.2\",41001,18446744073709551615\n",
+		},
+		{
+			name:   "JSONEachRow",
+			format: "JSONEachRow",
+			dump: `{"timeInserted":"2022-03-01 10:00:00","sourceIP":"10.10.0.1","sourceTransportPort":41000,"octetTotalCount":"1500"}` + "\n" +
+				`{"octetTotalCount":"18446744073709551615","sourceTransportPort":41001,"sourceIP":"10.10.0.2","timeInserted":"1646128801"}` + "\n",
+		},
+		{
+			name:   "Native",
+			format: "Native",
+			dump:   string(native),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			columns, records, err := readAll(t, strings.NewReader(tc.dump), tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(columns, expectedColumns) {
+				t.Errorf("expected columns %v, got %v", expectedColumns, columns)
+			}
+			if len(records) != len(expectedRecords) {
+				t.Fatalf("expected %d records, got %d", len(expectedRecords), len(records))
+			}
+			for i, record := range records {
+				for c, value := range record {
+					if tm, ok := value.(time.Time); ok {
+						value = tm.UTC()
+					}
+					if !reflect.DeepEqual(value, expectedRecords[i][c]) {
+						t.Errorf("record %d, column %s: expected %#v, got %#v", i, columns[c].name, expectedRecords[i][c], value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCSVReaderAllColumns(t *testing.T) {
+	// a dump without names holds all columns of the table in order
+	fields := make([]string, len(tableColumns))
+	for i, column := range tableColumns {
+		switch column.typ {
+		case "DateTime":
+			fields[i] = "2022-03-01 10:00:00"
+		case "String":
+			fields[i] = column.name
+		default:
+			fields[i] = "1"
+		}
+	}
+	columns, records, err := readAll(t, strings.NewReader(strings.Join(fields, ",")+"\n"), "CSV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columnNames(columns) != columnNames(tableColumns) || len(records) != 1 {
+		t.Fatalf("expected one record of all columns, got %d records of %s", len(records), columnNames(columns))
+	}
+	for i, column := range tableColumns {
+		if column.typ == "String" && records[0][i] != column.name {
+			t.Errorf("column %s: expected %q, got %#v", column.name, column.name, records[0][i])
+		}
+	}
+}
+
+func TestRecordReaderErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		format   string
+		dump     string
+		expected string
+	}{
+		{
+			name:     "CSV with an unknown column",
+			format:   "CSVWithNames",
+			dump:     "timeInserted,color\n2022-03-01 10:00:00,red\n",
+			expected: `unknown column "color"`,
+		},
+		{
+			name:     "CSV with an invalid value",
+			format:   "CSVWithNames",
+			dump:     "timeInserted,sourceTransportPort\n2022-03-01 10:00:00,70000\n",
+			expected: "column sourceTransportPort",
+		},
+		{
+			name:   "JSONEachRow with a missing column",
+			format: "JSONEachRow",
+			dump: `{"timeInserted":"2022-03-01 10:00:00","sourceIP":"10.10.0.1"}` + "\n" +
+				`{"timeInserted":"2022-03-01 10:00:01"}` + "\n",
+			expected: "expected 2 columns, got 1",
+		},
+		{
+			name:   "JSONEachRow with an unexpected column",
+			format: "JSONEachRow",
+			dump: `{"timeInserted":"2022-03-01 10:00:00","sourceIP":"10.10.0.1"}` + "\n" +
+				`{"timeInserted":"2022-03-01 10:00:01","destinationIP":"10.10.0.2"}` + "\n",
+			expected: `unexpected column "destinationIP"`,
+		},
+		{
+			name:     "Native with a LowCardinality column",
+			format:   "Native",
+			dump:     string(nativeBlock(1, nativeColumn{"sourcePodName", "LowCardinality(String)", nil})),
+			expected: "unsupported column type LowCardinality(String)",
+		},
+		{
+			name:     "Native with a Nullable column",
+			format:   "Native",
+			dump:     string(nativeBlock(1, nativeColumn{"sourcePodName", "Nullable(String)", nil})),
+			expected: "unsupported column type Nullable(String)",
+		},
+		{
+			name:   "truncated Native block",
+			format: "Native",
+			dump: string(nativeBlock(2,
+				nativeColumn{"timeInserted", "DateTime", []interface{}{fixtureTime1}})),
+			expected: io.ErrUnexpectedEOF.Error(),
+		},
+		{
+			name:     "Native block with too many rows",
+			format:   "Native",
+			dump:     "\x01\xff\xff\xff\xff\xff\xff\xff\xff\x7f",
+			expected: "invalid Native block: 9223372036854775807 rows",
+		},
+		{
+			name:     "Native block with too many columns",
+			format:   "Native",
+			dump:     "\xff\xff\xff\xff\x0f\x01",
+			expected: "invalid Native block: 4294967295 columns",
+		},
+		{
+			name:     "Native string too long",
+			format:   "Native",
+			dump:     "\x01\x01\xff\xff\xff\xff\x0f",
+			expected: "invalid Native string of 4294967295 bytes",
+		},
+		{
+			name:   "Native blocks with different columns",
+			format: "Native",
+			dump: string(append(
+				nativeBlock(1, nativeColumn{"timeInserted", "DateTime", []interface{}{fixtureTime1}}),
+				nativeBlock(1, nativeColumn{"flowStartSeconds", "DateTime", []interface{}{fixtureTime1}})...)),
+			expected: "block columns flowStartSeconds differ from timeInserted",
+		},
+		{
+			name:     "unknown format",
+			format:   "Parquet",
+			expected: `unknown input format "Parquet"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := readAll(t, strings.NewReader(tc.dump), tc.format)
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("expected an error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayerBatches(t *testing.T) {
+	dump := "timeInserted,sourceIP\n" +
+		"2022-03-01 10:00:00,10.10.0.1\n" +
+		"2022-03-01 10:00:00,10.10.0.2\n" +
+		"2022-03-01 10:00:00,10.10.0.3\n" +
+		"2022-03-01 10:00:05,10.10.0.4\n"
+	reader, err := newRecordReader(strings.NewReader(dump), "CSVWithNames", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := newReplayer(reader, 2, false)
+	var offsets []time.Duration
+	var sizes []int
+	for {
+		batch, err := replay.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, batch.offset)
+		sizes = append(sizes, len(batch.rows))
+	}
+	// the records of a second are split by the max batch size
+	if expected := []time.Duration{0, 0, 5 * time.Second}; !reflect.DeepEqual(offsets, expected) {
+		t.Errorf("expected offsets %v, got %v", expected, offsets)
+	}
+	if expected := []int{2, 1, 1}; !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected batch sizes %v, got %v", expected, sizes)
+	}
+}
+
+func TestReplayerRejectsUnsortedDump(t *testing.T) {
+	dump := "timeInserted,sourceIP\n" +
+		"2022-03-01 10:00:05,10.10.0.1\n" +
+		"2022-03-01 10:00:00,10.10.0.2\n"
+	reader, err := newRecordReader(strings.NewReader(dump), "CSVWithNames", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := newReplayer(reader, 10, false)
+	for {
+		batch, err := replay.next()
+		if err == io.EOF {
+			t.Fatal("expected the unsorted dump to be rejected")
+		}
+		if err != nil {
+			if !strings.Contains(err.Error(), "not sorted by timeInserted") {
+				t.Errorf("expected an unsorted dump error, got %v", err)
+			}
+			return
+		}
+		if batch.offset < 0 {
+			t.Fatalf("got a negative offset %s", batch.offset)
+		}
+	}
+}
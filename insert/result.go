@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// commitSample is the outcome of a single commit.
+type commitSample struct {
+	Index     int       `json:"index"`
+	Start     time.Time `json:"start"`
+	Rows      int       `json:"rows"`
+	Rate      float64   `json:"rate,omitempty"`
+	LagMs     float64   `json:"lagMs"`
+	LatencyMs float64   `json:"latencyMs"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// runSummary holds the statistics computed over all samples of a run.
+type runSummary struct {
+	Commits       int     `json:"commits"`
+	Succeeded     int     `json:"succeeded"`
+	Failed        int     `json:"failed"`
+	Availability  float64 `json:"availability"`
+	RowsInserted  int     `json:"rowsInserted"`
+	DurationSec   float64 `json:"durationSec"`
+	InsertRate    float64 `json:"insertRate"`
+	TargetRate    float64 `json:"targetRate"`
+	LatencyMinMs  float64 `json:"latencyMinMs"`
+	LatencyMeanMs float64 `json:"latencyMeanMs"`
+	LatencyP50Ms  float64 `json:"latencyP50Ms"`
+	LatencyP95Ms  float64 `json:"latencyP95Ms"`
+	LatencyP99Ms  float64 `json:"latencyP99Ms"`
+	LatencyMaxMs  float64 `json:"latencyMaxMs"`
+	LagMeanMs     float64 `json:"lagMeanMs"`
+	LagMaxMs      float64 `json:"lagMaxMs"`
+	// Maximum values of the usage samples.
+	MaxActiveParts  uint64 `json:"maxActiveParts"`
+	MaxAsyncInserts uint64 `json:"maxAsyncInserts,omitempty"`
+	MaxAsyncBytes   uint64 `json:"maxAsyncBytes,omitempty"`
+}
+
+// runResult is the machine-readable record of one benchmark run.
+type runResult struct {
+	ID            string            `json:"id"`
+	Flags         map[string]string `json:"flags"`
+	StartTime     time.Time         `json:"startTime"`
+	EndTime       time.Time         `json:"endTime"`
+	ServerVersion string            `json:"serverVersion"`
+	// Driver is the client library of the writer, empty in the results of the
+	// builds on clickhouse-go v1.
+	Driver         string            `json:"driver,omitempty"`
+	ServerSettings map[string]string `json:"serverSettings"`
+	Samples        []commitSample    `json:"samples"`
+	Usage          []usageSample     `json:"usage"`
+	Summary        runSummary        `json:"summary"`
+	Harness        *harnessReport    `json:"harness,omitempty"`
+}
+
+// recorder collects commit samples from concurrent writers.
+type recorder struct {
+	mutex   sync.Mutex
+	samples []commitSample
+}
+
+func (r *recorder) add(sample commitSample) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.samples = append(r.samples, sample)
+}
+
+// snapshot returns a copy of the samples sorted by commit index.
+func (r *recorder) snapshot() []commitSample {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	samples := make([]commitSample, len(r.samples))
+	copy(samples, r.samples)
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Index < samples[j].Index })
+	return samples
+}
+
+// runID returns the ID of a run started at start: the start time to the millisecond
+// and a random suffix, so that runs started together do not overwrite their results.
+func runID(start time.Time) string {
+	suffix := make([]byte, 2)
+	if _, err := rand.Read(suffix); err != nil {
+		klog.Errorf("failed to generate the run ID suffix: %v", err)
+	}
+	return fmt.Sprintf("%s-%x", start.Format("20060102-150405.000"), suffix)
+}
+
+// newRunResult captures the flags and server metadata at the start of a run.
+func newRunResult(connect *sql.DB, fs *flag.FlagSet) *runResult {
+	start := time.Now()
+	result := &runResult{
+		ID:             runID(start),
+		Flags:          map[string]string{},
+		StartTime:      start,
+		ServerSettings: map[string]string{},
+		Driver:         clientDriver(),
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		result.Flags[f.Name] = f.Value.String()
+	})
+	if connect == nil {
+		return result
+	}
+	if err := connect.QueryRow("SELECT version()").Scan(&result.ServerVersion); err != nil {
+		klog.Errorf("failed to get clickhouse version: %v", err)
+	}
+	for prefix, query := range map[string]string{
+		"":            "SELECT name, value FROM system.settings WHERE changed",
+		"merge_tree.": "SELECT name, value FROM system.merge_tree_settings WHERE changed",
+	} {
+		if err := readSettings(connect, query, prefix, result.ServerSettings); err != nil {
+			klog.Errorf("failed to get clickhouse settings: %v", err)
+		}
+	}
+	return result
+}
+
+// clientDriver returns the module and version of the ClickHouse client the binary
+// is built with.
+func clientDriver() string {
+	const module = "github.com/ClickHouse/clickhouse-go/v2"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == module {
+				return module + " " + dep.Version
+			}
+		}
+	}
+	return module
+}
+
+func readSettings(connect *sql.DB, query, prefix string, settings map[string]string) error {
+	rows, err := connect.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		settings[prefix+name] = value
+	}
+	return rows.Err()
+}
+
+// finish stamps the end time and computes the summary from the samples.
+func (r *runResult) finish(samples []commitSample, usage []usageSample) {
+	r.EndTime = time.Now()
+	r.Samples = samples
+	r.Usage = usage
+	summary := runSummary{
+		Commits:     len(samples),
+		DurationSec: r.EndTime.Sub(r.StartTime).Seconds(),
+		TargetRate:  targetRate(),
+	}
+	var latencies []float64
+	var lagSum float64
+	for _, s := range samples {
+		lagSum += s.LagMs
+		if s.LagMs > summary.LagMaxMs {
+			summary.LagMaxMs = s.LagMs
+		}
+		if s.Success {
+			summary.Succeeded++
+			summary.RowsInserted += s.Rows
+			latencies = append(latencies, s.LatencyMs)
+		} else {
+			summary.Failed++
+		}
+	}
+	for _, u := range usage {
+		if u.ActiveParts > summary.MaxActiveParts {
+			summary.MaxActiveParts = u.ActiveParts
+		}
+		if u.AsyncInserts > summary.MaxAsyncInserts {
+			summary.MaxAsyncInserts = u.AsyncInserts
+		}
+		if u.AsyncBytes > summary.MaxAsyncBytes {
+			summary.MaxAsyncBytes = u.AsyncBytes
+		}
+	}
+	if summary.Commits > 0 {
+		summary.Availability = float64(summary.Succeeded) / float64(summary.Commits)
+		summary.LagMeanMs = lagSum / float64(summary.Commits)
+	}
+	if summary.DurationSec > 0 {
+		summary.InsertRate = float64(summary.RowsInserted) / summary.DurationSec
+	}
+	if len(latencies) > 0 {
+		sort.Float64s(latencies)
+		var sum float64
+		for _, l := range latencies {
+			sum += l
+		}
+		summary.LatencyMinMs = latencies[0]
+		summary.LatencyMaxMs = latencies[len(latencies)-1]
+		summary.LatencyMeanMs = sum / float64(len(latencies))
+		summary.LatencyP50Ms = percentile(latencies, 0.50)
+		summary.LatencyP95Ms = percentile(latencies, 0.95)
+		summary.LatencyP99Ms = percentile(latencies, 0.99)
+	}
+	r.Summary = summary
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// write stores the run as <id>.json and <id>_samples.csv in the output directory
+// and appends one summary row to runs.csv.
+func (r *runResult) write(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, r.ID+".json"), data, 0644); err != nil {
+		return err
+	}
+	if err := r.writeSamples(filepath.Join(dir, r.ID+"_samples.csv")); err != nil {
+		return err
+	}
+	return r.appendRun(filepath.Join(dir, "runs.csv"))
+}
+
+// samplesHeader is the header of <id>_samples.csv, the fields of commitSample.
+var samplesHeader = []string{"index", "start", "rows", "rate", "lag_ms", "latency_ms", "success", "error"}
+
+func (r *runResult) writeSamples(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write(samplesHeader)
+	for _, s := range r.Samples {
+		w.Write([]string{
+			strconv.Itoa(s.Index),
+			s.Start.Format(time.RFC3339Nano),
+			strconv.Itoa(s.Rows),
+			formatFloat(s.Rate),
+			formatFloat(s.LagMs),
+			formatFloat(s.LatencyMs),
+			strconv.FormatBool(s.Success),
+			s.Error,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// runsHeader is the header of runs.csv, one summary row per run.
+var runsHeader = []string{"id", "start", "end", "server_version", "flags",
+	"commits", "succeeded", "failed", "availability", "rows_inserted", "duration_sec", "insert_rate",
+	"latency_min_ms", "latency_mean_ms", "latency_p50_ms", "latency_p95_ms", "latency_p99_ms", "latency_max_ms"}
+
+// appendRun appends the summary of the run to runs.csv, writing the header to a new
+// file. An existing file with another header is refused rather than misaligning the
+// rows of the runs.
+func (r *runResult) appendRun(path string) error {
+	if err := checkCSVHeader(path, runsHeader); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if info, err := f.Stat(); err != nil {
+		return err
+	} else if info.Size() == 0 {
+		w.Write(runsHeader)
+	}
+	s := r.Summary
+	w.Write([]string{
+		r.ID, r.StartTime.Format(time.RFC3339), r.EndTime.Format(time.RFC3339), r.ServerVersion, r.flagString(),
+		strconv.Itoa(s.Commits), strconv.Itoa(s.Succeeded), strconv.Itoa(s.Failed),
+		formatFloat(s.Availability), strconv.Itoa(s.RowsInserted), formatFloat(s.DurationSec), formatFloat(s.InsertRate),
+		formatFloat(s.LatencyMinMs), formatFloat(s.LatencyMeanMs), formatFloat(s.LatencyP50Ms),
+		formatFloat(s.LatencyP95Ms), formatFloat(s.LatencyP99Ms), formatFloat(s.LatencyMaxMs),
+	})
+	w.Flush()
+	return w.Error()
+}
+
+// checkCSVHeader returns an error unless the CSV file at path is empty or starts with
+// header, an error satisfying os.IsNotExist if there is no file.
+func checkCSVHeader(path string, header []string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	first, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the header of %s: %v", path, err)
+	}
+	if strings.Join(first, ",") != strings.Join(header, ",") {
+		return fmt.Errorf("%s has the header %q instead of %q, choose another output directory", path, strings.Join(first, ","), strings.Join(header, ","))
+	}
+	return nil
+}
+
+// flagString returns the flags of the run in command-line form, sorted by name.
+func (r *runResult) flagString() string {
+	names := make([]string, 0, len(r.Flags))
+	for name := range r.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("-%s=%s", name, r.Flags[name])
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// logLine is the human-readable summary appended to test.log.
+func (r *runResult) logLine() string {
+	return fmt.Sprintf("time=%s, memory_size=%dg, batch_size=%d, batch_frequency=%s, insert_rate=%g, achieved_rate=%.1f, availability=%f, duration=%d\n",
+		r.StartTime.Format(time.RFC3339), memorySize, recordPerCommit, commitInterval(), r.Summary.TargetRate,
+		r.Summary.InsertRate, r.Summary.Availability, int(r.Summary.DurationSec))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readCSV returns the records of the CSV file at path.
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func testRunResult(id string) *runResult {
+	start := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	r := &runResult{ID: id, Flags: map[string]string{"r": "1000"}, StartTime: start}
+	r.finish([]commitSample{
+		{Index: 0, Start: start, Rows: 1000, Rate: 500, LagMs: 1, LatencyMs: 20, Success: true},
+		{Index: 1, Start: start.Add(time.Second), Rows: 1000, Rate: 500, LatencyMs: 30, Error: "timeout"},
+	}, nil)
+	return r
+}
+
+func TestRunResultWrite(t *testing.T) {
+	dir := t.TempDir()
+	for _, id := range []string{"run-1", "run-2"} {
+		if err := testRunResult(id).write(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs := readCSV(t, filepath.Join(dir, "runs.csv"))
+	if len(runs) != 3 || strings.Join(runs[0], ",") != strings.Join(runsHeader, ",") || runs[1][0] != "run-1" || runs[2][0] != "run-2" {
+		t.Errorf("expected the header and the rows of both runs, got %q", runs)
+	}
+	samples := readCSV(t, filepath.Join(dir, "run-1_samples.csv"))
+	expected := [][]string{
+		samplesHeader,
+		{"0", "2022-03-01T10:00:00Z", "1000", "500", "1", "20", "true", ""},
+		{"1", "2022-03-01T10:00:01Z", "1000", "500", "0", "30", "false", "timeout"},
+	}
+	if len(samples) != len(expected) {
+		t.Fatalf("expected %d lines, got %q", len(expected), samples)
+	}
+	for i := range expected {
+		if strings.Join(samples[i], ",") != strings.Join(expected[i], ",") {
+			t.Errorf("line %d: expected %q, got %q", i, expected[i], samples[i])
+		}
+	}
+}
+
+func TestRunResultRefusesOtherRunsHeader(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "runs.csv")
+	old := "id,start,end,flags,commits\nold,2022-03-01T10:00:00Z,2022-03-01T10:01:00Z,-r=1000,60\n"
+	if err := os.WriteFile(path, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := testRunResult("run-1").write(dir); err == nil || !strings.Contains(err.Error(), "choose another output directory") {
+		t.Fatalf("expected an error for runs.csv with another header, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != old {
+		t.Errorf("expected runs.csv to be left unchanged, got %q", data)
+	}
+}
@@ -8,43 +8,55 @@ import (
 	"os"
 	"os/signal"
 	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
 
-	"gonum.org/v1/plot"
-	"gonum.org/v1/plot/plotter"
-	"gonum.org/v1/plot/vg"
-
-	"github.com/ClickHouse/clickhouse-go"
+	"github.com/ClickHouse/clickhouse-go/v2"
 	"k8s.io/klog/v2"
 )
 
-// var availability []int
-var recordPerCommit, commitNum, insertInterval, memorySize int
-var availableTime, totalTime int32
-var host string
+var recordPerCommit, commitNum, memorySize int
+var workers, maxInFlight int
+var insertRate float64
+var host, outputDir, plotFormat, loadMode, profilePath, writerType string
+var nativePort, httpPort int
+var asyncInsert, waitForAsyncInsert bool
+var httpFormat, httpCompression string
+var insertInterval, sampleInterval time.Duration
 
-// var timeTakenInMs int64
+var profile *loadProfile
+var result *runResult
+var commits recorder
+var usage *usageSampler
+var monitor *storageMonitor
+var logResultOnce sync.Once
 
 // log results when the program is interupted
 func SetupCloseHandler() {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		// writeToFile(availability)
 		logResult()
 		os.Exit(0)
 	}()
 }
 
 func createClickHouseClient() *sql.DB {
-	hostAddress := fmt.Sprintf("tcp://%s:9000?debug=true&username=clickhouse_operator&password=clickhouse_operator_password", host)
-	connect, err := sql.Open("clickhouse", hostAddress)
+	tlsConfig, err := tlsConfig()
 	if err != nil {
 		klog.Fatal(err)
 	}
+	connect := clickhouse.OpenDB(&clickhouse.Options{
+		Addr: []string{fmt.Sprintf("%s:%d", host, nativePort)},
+		Auth: clickhouse.Auth{
+			Database: "default",
+			Username: "clickhouse_operator",
+			Password: "clickhouse_operator_password",
+		},
+		TLS:   tlsConfig,
+		Debug: true,
+	})
 	if err := connect.Ping(); err != nil {
 		if exception, ok := err.(*clickhouse.Exception); ok {
 			fmt.Printf("[%d] %s \n%s\n", exception.Code, exception.Message, exception.StackTrace)
@@ -60,19 +72,20 @@ func getRandIP() string {
 	return fmt.Sprintf("%d.%d.%d.%d", rand.Intn(256), rand.Intn(256), rand.Intn(256), rand.Intn(256))
 }
 
-func addFakeRecord(stmt *sql.Stmt) {
-	if _, err := stmt.Exec(
+// fakeRecord returns a random flow record with the values of flowColumns.
+func fakeRecord() []interface{} {
+	return []interface{}{
 		time.Now(),
 		time.Now(),
 		time.Now(),
 		time.Now(),
 		time.Now(),
-		0,
+		uint8(0),
 		getRandIP(),
 		getRandIP(),
 		uint16(rand.Intn(65535)),
 		uint16(rand.Intn(65535)),
-		6,
+		uint8(6),
 		uint64(rand.Int()),
 		uint64(rand.Int()),
 		uint64(rand.Int()),
@@ -93,15 +106,15 @@ func addFakeRecord(stmt *sql.Stmt) {
 		fmt.Sprintf("PolicyName-%d", rand.Int()),
 		fmt.Sprintf("PolicyNameSpace-%d", rand.Int()),
 		fmt.Sprintf("PolicyRuleName-%d", rand.Int()),
-		1,
-		1,
+		uint8(1),
+		uint8(1),
 		fmt.Sprintf("PolicyName-%d", rand.Int()),
 		fmt.Sprintf("PolicyNameSpace-%d", rand.Int()),
 		fmt.Sprintf("PolicyRuleName-%d", rand.Int()),
-		1,
-		1,
+		uint8(1),
+		uint8(1),
 		"tcpState",
-		0,
+		uint8(0),
 		fmt.Sprintf("PodLabels-%d", rand.Int()),
 		fmt.Sprintf("PodLabels-%d", rand.Int()),
 		uint64(rand.Int()),
@@ -110,117 +123,195 @@ func addFakeRecord(stmt *sql.Stmt) {
 		uint64(rand.Int()),
 		uint64(rand.Int()),
 		uint64(rand.Int()),
-	); err != nil {
-		klog.Fatal(err)
 	}
 }
 
-func writeRecords(connect *sql.DB, wg *sync.WaitGroup) {
-	defer wg.Done()
-	var (
-		tx, _   = connect.Begin()
-		stmt, _ = tx.Prepare("INSERT INTO flows (timeInserted,flowStartSeconds,flowEndSeconds,flowEndSecondsFromSourceNode,flowEndSecondsFromDestinationNode,flowEndReason,sourceIP,destinationIP,sourceTransportPort,destinationTransportPort,protocolIdentifier,packetTotalCount,octetTotalCount,packetDeltaCount,octetDeltaCount,reversePacketTotalCount,reverseOctetTotalCount,reversePacketDeltaCount,reverseOctetDeltaCount,sourcePodName,sourcePodNamespace,sourceNodeName,destinationPodName,destinationPodNamespace,destinationNodeName,destinationClusterIP,destinationServicePort,destinationServicePortName,ingressNetworkPolicyName,ingressNetworkPolicyNamespace,ingressNetworkPolicyRuleName,ingressNetworkPolicyRuleAction,ingressNetworkPolicyType, egressNetworkPolicyName,egressNetworkPolicyNamespace,egressNetworkPolicyRuleName,egressNetworkPolicyRuleAction,egressNetworkPolicyType,tcpState,flowType,sourcePodLabels,destinationPodLabels,throughput,reverseThroughput,throughputFromSourceNode,throughputFromDestinationNode,reverseThroughputFromSourceNode,reverseThroughputFromDestinationNode) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
-	)
-	defer stmt.Close()
-	for j := 0; j < recordPerCommit; j++ {
-		addFakeRecord(stmt)
-	}
-	// startTime := time.Now()
-	if err := tx.Commit(); err != nil {
+func writeRecords(writer batchWriter, job commitJob) {
+	columns, rows := job.columns, job.rows
+	if rows == nil {
+		columns = flowColumns
+		rows = make([][]interface{}, recordPerCommit)
+		for j := range rows {
+			rows[j] = fakeRecord()
+		}
+	}
+	sample := commitSample{Index: job.index, Start: time.Now(), Rate: job.rate, Rows: len(rows)}
+	if !job.scheduled.IsZero() {
+		sample.LagMs = float64(sample.Start.Sub(job.scheduled).Microseconds()) / 1000
+	}
+	if err := writer.writeBatch(insertContext(), columns, rows); err != nil {
 		fmt.Printf("Error: %v", err)
-		// availability = append(availability, 0)
+		sample.Error = err.Error()
 	} else {
-		atomic.AddInt32(&availableTime, 1)
-		// availability = append(availability, 1)
+		sample.Success = true
 	}
-	// atomic.AddInt64(&timeTakenInMs, int64(time.Since(startTime).Milliseconds()))
-	atomic.AddInt32(&totalTime, 1)
-
+	sample.LatencyMs = float64(time.Since(sample.Start).Microseconds()) / 1000
+	commits.add(sample)
 }
 
+// logResult writes the structured result of the run and its charts to the output
+// directory and appends a one-line summary to test.log.
 func logResult() {
+	// the close handler and the end of the run may both get here, the result is
+	// written once and the later caller waits for it
+	logResultOnce.Do(writeResult)
+}
+
+func writeResult() {
+	result.finish(commits.snapshot(), usage.stop())
+	if monitor != nil {
+		result.Harness = monitor.report(result)
+	}
+	if err := result.write(outputDir); err != nil {
+		klog.Error(err)
+	}
+	if plotFormat != "" {
+		if err := plotResult(result, outputDir, plotFormat); err != nil {
+			klog.Error(err)
+		}
+	}
 	f, err := os.OpenFile("test.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		klog.Error(err)
+		return
 	}
 	defer f.Close()
-	result := fmt.Sprintf("memory_size=%dg, batch_size=%d, batch_frequecy=%ds, insert_rate=%d, availibility=%f, duration=%d\n", memorySize, recordPerCommit, insertInterval, recordPerCommit/insertInterval, float32(availableTime)/float32(totalTime), int(totalTime)*insertInterval)
-	if _, err := f.WriteString(result); err != nil {
+	if _, err := f.WriteString(result.logLine()); err != nil {
 		klog.Error(err)
 	}
 }
 
-func main() {
-	// example: write 1,000 records in a batch, 1800 writes in total,
-	// insertion interval at 1s, log with memory size 2G and connect to clickhouse host at 127.0.0.1
-	// go run . -r 1000 -c 1800 -i 1 -m 2 -h 127.0.0.1
-	flag.IntVar(&recordPerCommit, "r", 1, "records number per commit")
-	flag.IntVar(&commitNum, "c", 1, "commits number")
-	flag.IntVar(&insertInterval, "i", 1, "insertion interval")
-	flag.IntVar(&memorySize, "m", 1, "memory size(Gb)")
-	flag.StringVar(&host, "h", "localhost", "Clickhouse address")
-	flag.Parse()
-
-	connect := createClickHouseClient()
-
-	SetupCloseHandler()
-	var wg sync.WaitGroup
-
-	for i := 0; i < commitNum; i++ {
-		fmt.Println(i)
-		wg.Add(1)
-		go writeRecords(connect, &wg)
-		time.Sleep(time.Duration(insertInterval) * time.Second)
-	}
-	wg.Wait()
-	// timeTakenInMs /= int64(commitNum)
-	// klog.InfoS("Logging...", "Time spent for each commit in ms", timeTakenInMs)
+// registerFlags defines the flags of a load run on fs.
+func registerFlags(fs *flag.FlagSet) {
+	fs.IntVar(&recordPerCommit, "r", 1, "records number per commit")
+	fs.IntVar(&commitNum, "c", 1, "commits number")
+	insertInterval = time.Second
+	fs.Var((*intervalFlag)(&insertInterval), "i", "insertion interval in seconds or as a duration, e.g. 250ms")
+	fs.Float64Var(&insertRate, "rate", 0, "target rows per second, overrides the insertion interval if set")
+	fs.StringVar(&loadMode, "mode", "open", "open: commit every insertion interval and track the lag, closed: each writer commits again an insertion interval after its previous commit")
+	fs.StringVar(&profilePath, "profile", "", "YAML load profile replayed in open mode instead of a constant rate")
+	registerWriterFlags(fs)
+}
 
-	logResult()
-	// plotAvailability(availability, commitNum)
-	// writeToFile(availability)
+// registerWriterFlags defines the flags shared by every command writing to ClickHouse on fs.
+func registerWriterFlags(fs *flag.FlagSet) {
+	fs.IntVar(&memorySize, "m", 1, "memory size(Gb)")
+	fs.StringVar(&host, "h", "localhost", "Clickhouse address")
+	fs.IntVar(&nativePort, "port", 9000, "Clickhouse native protocol port")
+	fs.StringVar(&outputDir, "o", "results", "directory for the JSON and CSV results")
+	fs.StringVar(&plotFormat, "plot", "", "render charts of the run as png or svg, disabled if empty")
+	fs.DurationVar(&sampleInterval, "sample-interval", 5*time.Second, "interval of sampling rows and disk usage, disabled if 0")
+	fs.IntVar(&workers, "workers", 4, "number of concurrent writers")
+	fs.IntVar(&maxInFlight, "max-in-flight", 0, "max commits scheduled but not finished in open-loop mode, defaults to the number of writers")
+	fs.StringVar(&writerType, "writer", "sql", "sql: database/sql prepared statement per row on the clickhouse-go v2 driver, not the legacy v1 path, native: clickhouse-go v2 batch with column append, http: POST to the HTTP interface")
+	fs.IntVar(&httpPort, "http-port", 8123, "port of the ClickHouse HTTP interface for the http writer")
+	fs.StringVar(&httpFormat, "format", "RowBinary", "format of the http writer: RowBinary, JSONEachRow or CSV")
+	fs.StringVar(&httpCompression, "compression", "none", "compression of the http writer: none, gzip or zstd")
+	fs.BoolVar(&asyncInsert, "async", false, "insert with async_insert=1 so that the server buffers small inserts")
+	fs.BoolVar(&waitForAsyncInsert, "wait-async", true, "wait_for_async_insert setting of asynchronous inserts")
+	registerTLSFlags(fs)
 }
 
-// Helpful functions not used in performance test
-func writeToFile(data []int) {
-	fileName := fmt.Sprintf("data_4g_%d_%ds.csv", recordPerCommit, insertInterval)
-	f, err := os.Create(fileName)
-	if err != nil {
-		klog.Error(err)
+// validateLoadFlags validates the flags of a load run and reads its load profile.
+func validateLoadFlags() {
+	if loadMode != "open" && loadMode != "closed" {
+		klog.Fatalf("unknown mode %q", loadMode)
 	}
-	defer f.Close()
-	for i, value := range data {
-		f.WriteString(fmt.Sprintf("%d, %d\n", i, value))
+	if profilePath != "" {
+		var err error
+		if profile, err = readLoadProfile(profilePath); err != nil {
+			klog.Fatal(err)
+		}
+		if loadMode != "open" {
+			klog.Fatal("load profiles require the open mode")
+		}
 	}
 }
 
-func plotAvailability(data []int, n int) {
-	p := plot.New()
-	p.Title.Text = "Availability recording time"
-	p.X.Label.Text = "time(second)"
-	p.Y.Label.Text = "Availability"
-
-	pts := make(plotter.XYs, n)
-	for i := range pts {
-		pts[i].X = float64(i)
-		pts[i].Y = float64(data[i])
+// startRun validates the parsed writer flags, connects to ClickHouse and starts recording the run.
+func startRun(fs *flag.FlagSet) *sql.DB {
+	if workers < 1 {
+		klog.Fatal("at least one writer is required")
+	}
+	if maxInFlight < workers {
+		maxInFlight = workers
 	}
 
-	scatterPlot, err := plotter.NewScatter(pts)
+	applyTLSPorts(fs)
+	connect := createClickHouseClient()
+	result = newRunResult(connect, fs)
+	usage = newUsageSampler(connect)
+	usage.start(sampleInterval)
+	return connect
+}
+
+// runLoad runs the commits of the load on a writer pool and returns once they are done.
+func runLoad(connect *sql.DB) {
+	writer, err := newBatchWriter(connect)
 	if err != nil {
-		panic(err)
+		klog.Fatal(err)
 	}
-	p.Add(scatterPlot)
-	if err := p.Save(4*vg.Inch, 4*vg.Inch, "availability.png"); err != nil {
-		panic(err)
+	pool := newWriterPool(writer, workers, maxInFlight)
+	if loadMode == "closed" {
+		pool.runClosedLoop(commitNum, commitInterval())
+	} else {
+		pool.runOpenLoop(commitNum, newLoadSchedule(time.Now(), recordPerCommit, targetRate(), profile))
 	}
+}
 
-	// s.GlyphStyle.Color = color.RGBA{R: 255, B: 128, A: 255}
+func main() {
+	// example: write 1,000 records in a batch, 1800 writes in total,
+	// insertion interval at 1s, log with memory size 2G and connect to clickhouse host at 127.0.0.1
+	// go run . -r 1000 -c 1800 -i 1 -m 2 -h 127.0.0.1
+	// write 250 records in a batch at 1,000 records per second, i.e. a batch every 250ms:
+	// go run . -r 250 -c 7200 -rate 1000 -m 2
+	// replay a load profile, see loadProfile for the format:
+	// go run . -r 500 -m 2 -profile profiles/eviction-limit.yaml
+	// insert with the native batch protocol of clickhouse-go v2 instead of database/sql:
+	// go run . -r 1000 -c 1800 -i 1 -m 2 -writer native
+	// or through the HTTP interface with a given format and compression:
+	// go run . -r 1000 -c 1800 -i 1 -m 2 -writer http -format JSONEachRow -compression zstd
+	// send many small concurrent asynchronous inserts, buffered by the server:
+	// go run . -r 10 -rate 2000 -c 100000 -workers 32 -max-in-flight 64 -async -wait-async=false
+	// compare the results of several runs:
+	// go run . compare results/*.json
+	// drive inserts while running the storage monitor in-process:
+	// go run . harness -r 1000 -rate 2000 -c 7200 -threshold 0.5
+	// replay a dump of clickhouse-client -q "SELECT * FROM flows FORMAT Native" > flows.native
+	// twice as fast with the timestamps moved to now:
+	// go run . replay -speed 2 -rewrite-time flows.native
+	// collect IPFIX records of the flow aggregator on port 4739 and insert them in batches:
+	// go run . collector -r 5000 -writer native
+	// and send fake records to it:
+	// go run . exporter -r 100 -c 600 -i 1 -transport tcp
+	// connect over TLS to the secure ports 9440 and 8443 with the certificates of deployment/generate-certs.sh:
+	// go run . -r 1000 -c 1800 -tls -tls-ca certs/ca.pem -tls-cert certs/client.pem -tls-key certs/client-key.pem
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "compare":
+			os.Exit(runCompare(os.Args[2:]))
+		case "harness":
+			os.Exit(runHarness(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "collector":
+			os.Exit(runCollector(os.Args[2:]))
+		case "exporter":
+			os.Exit(runExporter(os.Args[2:]))
+		}
+	}
+	registerFlags(flag.CommandLine)
+	flag.Parse()
+	validateLoadFlags()
+	connect := startRun(flag.CommandLine)
 
-}
+	SetupCloseHandler()
+	runLoad(connect)
 
-func setTTLMergeTimeout(connect *sql.DB) {
-	if _, err := connect.Exec("ALTER TABLE default.antrea MODIFY SETTING merge_with_ttl_timeout = 600"); err != nil {
-		klog.Fatal(err)
+	logResult()
+	klog.Infof("Insert rate: achieved %.1f rows/s, target %.1f rows/s", result.Summary.InsertRate, result.Summary.TargetRate)
+	if asyncInsert {
+		klog.Infof("Asynchronous inserts: max queued %d (%d bytes), max active parts %d",
+			result.Summary.MaxAsyncInserts, result.Summary.MaxAsyncBytes, result.Summary.MaxActiveParts)
 	}
 }
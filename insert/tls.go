@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+
+	"clickhouse/common/tlsconfig"
+)
+
+var tlsFlags tlsconfig.Flags
+
+// registerTLSFlags defines the flags of the TLS connections to ClickHouse on fs.
+func registerTLSFlags(fs *flag.FlagSet) {
+	tlsFlags.Register(fs, "connect over TLS, to the secure ports 9440 and 8443 unless -port or -http-port is set")
+}
+
+// applyTLSPorts switches the ports not set on fs to the secure ports of ClickHouse
+// when TLS is enabled.
+func applyTLSPorts(fs *flag.FlagSet) {
+	if !tlsFlags.Enabled {
+		return
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["port"] {
+		nativePort = 9440
+	}
+	if !set["http-port"] {
+		httpPort = 8443
+	}
+}
+
+// tlsConfig returns the TLS configuration of the connections to ClickHouse, nil
+// without -tls.
+func tlsConfig() (*tls.Config, error) {
+	return tlsFlags.Config()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// usageSample is a snapshot of the table size and disk usage taken during a run.
+// The asynchronous insert queue is only sampled for runs with asynchronous inserts.
+type usageSample struct {
+	Time         time.Time `json:"time"`
+	Rows         uint64    `json:"rows"`
+	ActiveParts  uint64    `json:"activeParts"`
+	DiskUsed     uint64    `json:"diskUsed"`
+	DiskTotal    uint64    `json:"diskTotal"`
+	AsyncInserts uint64    `json:"asyncInserts,omitempty"`
+	AsyncBytes   uint64    `json:"asyncBytes,omitempty"`
+}
+
+// usageSampler polls ClickHouse for usage samples until it is stopped.
+type usageSampler struct {
+	connect  *sql.DB
+	mutex    sync.Mutex
+	samples  []usageSample
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	doneCh   chan struct{}
+}
+
+func newUsageSampler(connect *sql.DB) *usageSampler {
+	return &usageSampler{
+		connect: connect,
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+}
+
+// start samples every interval in the background. A non-positive interval disables sampling.
+func (s *usageSampler) start(interval time.Duration) {
+	if interval <= 0 || s.connect == nil {
+		close(s.doneCh)
+		return
+	}
+	go func() {
+		defer close(s.doneCh)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.sample()
+			select {
+			case <-s.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stop ends the sampling and returns the collected samples.
+func (s *usageSampler) stop() []usageSample {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	<-s.doneCh
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.samples
+}
+
+func (s *usageSampler) sample() {
+	sample := usageSample{Time: time.Now()}
+	if err := s.connect.QueryRow("SELECT COUNT() FROM flows").Scan(&sample.Rows); err != nil {
+		klog.Errorf("failed to count rows: %v", err)
+		return
+	}
+	if err := s.connect.QueryRow("SELECT COUNT() FROM system.parts WHERE database = currentDatabase() AND table = 'flows' AND active").Scan(&sample.ActiveParts); err != nil {
+		klog.Errorf("failed to count parts: %v", err)
+		return
+	}
+	if err := s.connect.QueryRow("SELECT sum(total_space - free_space), sum(total_space) FROM system.disks").Scan(&sample.DiskUsed, &sample.DiskTotal); err != nil {
+		klog.Errorf("failed to get disk usage: %v", err)
+		return
+	}
+	if asyncInsert {
+		if err := s.connect.QueryRow("SELECT COUNT(), sum(total_bytes) FROM system.asynchronous_inserts WHERE table = 'flows'").Scan(&sample.AsyncInserts, &sample.AsyncBytes); err != nil {
+			klog.Errorf("failed to get asynchronous inserts: %v", err)
+			return
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.samples = append(s.samples, sample)
+}
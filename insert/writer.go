@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// batchWriter inserts a batch of rows into the flows table. Every row holds the
+// values of columns in order.
+type batchWriter interface {
+	writeBatch(ctx context.Context, columns []flowColumn, rows [][]interface{}) error
+}
+
+// insertSettings returns the settings sent with every insert, enabling asynchronous
+// inserts if requested by the -async flag.
+func insertSettings() clickhouse.Settings {
+	if !asyncInsert {
+		return nil
+	}
+	wait := 0
+	if waitForAsyncInsert {
+		wait = 1
+	}
+	return clickhouse.Settings{
+		"async_insert":          1,
+		"wait_for_async_insert": wait,
+	}
+}
+
+// insertContext returns the context of an insert carrying the insert settings.
+func insertContext() context.Context {
+	return clickhouse.Context(context.Background(), clickhouse.WithSettings(insertSettings()))
+}
+
+// newBatchWriter returns the writer selected by the -writer flag.
+func newBatchWriter(connect *sql.DB) (batchWriter, error) {
+	switch writerType {
+	case "sql":
+		return &sqlWriter{connect: connect}, nil
+	case "native":
+		tlsConfig, err := tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		conn, err := clickhouse.Open(&clickhouse.Options{
+			Addr: []string{fmt.Sprintf("%s:%d", host, nativePort)},
+			Auth: clickhouse.Auth{
+				Database: "default",
+				Username: "clickhouse_operator",
+				Password: "clickhouse_operator_password",
+			},
+			TLS:          tlsConfig,
+			MaxOpenConns: workers,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &nativeWriter{conn: conn}, nil
+	case "http":
+		tlsConfig, err := tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		return newHTTPWriter(host, httpPort, httpFormat, httpCompression, tlsConfig)
+	default:
+		return nil, fmt.Errorf("unknown writer %q", writerType)
+	}
+}
+
+// sqlWriter inserts a batch through database/sql: a prepared statement executed row
+// by row inside a transaction, committed as one block. It runs on the database/sql
+// driver of clickhouse-go v2, not on the legacy v1 block path: the v1 driver registers
+// the same driver name and cannot be linked in the same binary. Only runs of builds
+// before the move to v2 measured v1, compare keeps them apart by their driver.
+type sqlWriter struct {
+	connect *sql.DB
+}
+
+func (w *sqlWriter) writeBatch(ctx context.Context, columns []flowColumn, rows [][]interface{}) error {
+	tx, err := w.connect.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	stmt, err := tx.PrepareContext(ctx, insertQuery(columns)+" VALUES ("+placeholders+")")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// nativeWriter inserts a batch with the native protocol of clickhouse-go v2,
+// appending whole columns to the batch instead of single rows.
+type nativeWriter struct {
+	conn driver.Conn
+}
+
+func (w *nativeWriter) writeBatch(ctx context.Context, columns []flowColumn, rows [][]interface{}) error {
+	batch, err := w.conn.PrepareBatch(ctx, insertQuery(columns))
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		for i := range columns {
+			values := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(rows[0][i])), 0, len(rows))
+			for _, row := range rows {
+				values = reflect.Append(values, reflect.ValueOf(row[i]))
+			}
+			if err := batch.Column(i).Append(values.Interface()); err != nil {
+				batch.Abort()
+				return fmt.Errorf("column %s: %v", columns[i].name, err)
+			}
+		}
+	}
+	return batch.Send()
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// httpWriter inserts a batch with one POST request to the HTTP interface of ClickHouse,
+// encoding the rows in RowBinary, JSONEachRow or CSV and optionally compressing the body.
+type httpWriter struct {
+	client      *http.Client
+	endpoint    string
+	format      string
+	compression string
+}
+
+// newHTTPWriter returns a writer to the HTTP interface at host:port, over HTTPS if
+// tlsConfig is set.
+func newHTTPWriter(host string, port int, format, compression string, tlsConfig *tls.Config) (*httpWriter, error) {
+	switch format {
+	case "RowBinary", "JSONEachRow", "CSV":
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	switch compression {
+	case "none":
+		compression = ""
+	case "gzip", "zstd":
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	return &httpWriter{
+		client: &http.Client{
+			Transport: &http.Transport{MaxIdleConnsPerHost: workers, TLSClientConfig: tlsConfig},
+		},
+		endpoint:    fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(host, strconv.Itoa(port))),
+		format:      format,
+		compression: compression,
+	}, nil
+}
+
+func (w *httpWriter) writeBatch(ctx context.Context, columns []flowColumn, rows [][]interface{}) error {
+	var body bytes.Buffer
+	if err := w.encode(&body, columns, rows); err != nil {
+		return err
+	}
+	query := url.Values{"query": {insertQuery(columns) + " FORMAT " + w.format}}
+	for name, value := range insertSettings() {
+		query.Set(name, fmt.Sprint(value))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.endpoint+"?"+query.Encode(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-ClickHouse-User", "clickhouse_operator")
+	req.Header.Set("X-ClickHouse-Key", "clickhouse_operator_password")
+	if w.compression != "" {
+		req.Header.Set("Content-Encoding", w.compression)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("insert failed with status %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// encode writes the rows in the format of the writer to body, compressed if configured.
+func (w *httpWriter) encode(body io.Writer, columns []flowColumn, rows [][]interface{}) error {
+	var out io.WriteCloser
+	switch w.compression {
+	case "gzip":
+		out = gzip.NewWriter(body)
+	case "zstd":
+		encoder, err := zstd.NewWriter(body)
+		if err != nil {
+			return err
+		}
+		out = encoder
+	default:
+		out = nopWriteCloser{body}
+	}
+	var err error
+	switch w.format {
+	case "RowBinary":
+		err = encodeRowBinary(out, rows)
+	case "JSONEachRow":
+		err = encodeJSONEachRow(out, columns, rows)
+	case "CSV":
+		err = encodeCSV(out, rows)
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// encodeRowBinary writes rows in the RowBinary format. time.Time values are written as
+// DateTime, i.e. seconds since the epoch.
+func encodeRowBinary(out io.Writer, rows [][]interface{}) error {
+	var buf bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	for _, row := range rows {
+		buf.Reset()
+		for _, value := range row {
+			switch v := value.(type) {
+			case time.Time:
+				binary.LittleEndian.PutUint32(scratch[:4], uint32(v.Unix()))
+				buf.Write(scratch[:4])
+			case uint8:
+				buf.WriteByte(v)
+			case uint16:
+				binary.LittleEndian.PutUint16(scratch[:2], v)
+				buf.Write(scratch[:2])
+			case uint32:
+				binary.LittleEndian.PutUint32(scratch[:4], v)
+				buf.Write(scratch[:4])
+			case uint64:
+				binary.LittleEndian.PutUint64(scratch[:8], v)
+				buf.Write(scratch[:8])
+			case string:
+				n := binary.PutUvarint(scratch[:], uint64(len(v)))
+				buf.Write(scratch[:n])
+				buf.WriteString(v)
+			default:
+				return fmt.Errorf("unsupported RowBinary value %T", value)
+			}
+		}
+		if _, err := out.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeJSONEachRow writes one JSON object per row, keeping the column order.
+func encodeJSONEachRow(out io.Writer, columns []flowColumn, rows [][]interface{}) error {
+	var buf bytes.Buffer
+	for _, row := range rows {
+		buf.Reset()
+		buf.WriteByte('{')
+		for i, value := range row {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if t, ok := value.(time.Time); ok {
+				value = t.Unix()
+			}
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(strconv.Quote(columns[i].name))
+			buf.WriteByte(':')
+			buf.Write(encoded)
+		}
+		buf.WriteString("}\n")
+		if _, err := out.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeCSV(out io.Writer, rows [][]interface{}) error {
+	w := csv.NewWriter(out)
+	record := make([]string, 0, len(flowColumns))
+	for _, row := range rows {
+		record = record[:0]
+		for _, value := range row {
+			if t, ok := value.(time.Time); ok {
+				value = t.Unix()
+			}
+			record = append(record, fmt.Sprint(value))
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
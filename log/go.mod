@@ -3,6 +3,7 @@ module clickhouse/log
 go 1.17
 
 require (
+	clickhouse/common v0.0.0
 	github.com/ClickHouse/clickhouse-go v1.5.3
 	k8s.io/klog/v2 v2.30.0
 )
@@ -18,3 +19,5 @@ require (
 	go.opentelemetry.io/otel v1.4.1 // indirect
 	go.opentelemetry.io/otel/trace v1.4.1 // indirect
 )
+
+replace clickhouse/common => ../common
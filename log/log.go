@@ -2,9 +2,14 @@ package main
 
 import (
 	"database/sql"
+	"flag"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -12,51 +17,183 @@ import (
 	"k8s.io/klog/v2"
 )
 
-var countSeries []uint64
+var host, username, password, format, outputPath string
+var port int
+var interval time.Duration
+var tables tableList
+var partLog bool
+var sink *sampleSink
 
-func logFunc(connect *sql.DB) {
-	rows, err := connect.Query("SELECT COUNT() FROM default.flows")
+// previous holds the last sample of every table.
+var previous = map[string]sample{}
+
+// tableList is a flag listing tables as database.table, comma separated or repeated.
+type tableList []string
+
+func (l *tableList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *tableList) Set(value string) error {
+	for _, table := range strings.Split(value, ",") {
+		if table = strings.TrimSpace(table); table != "" {
+			*l = append(*l, table)
+		}
+	}
+	return nil
+}
+
+// takeSample queries the size of a table, given as database.table, and its running
+// merges and mutations.
+func takeSample(connect *sql.DB, table string) (sample, error) {
+	// the sample is stamped when its row count is taken, so that the rates are not
+	// skewed by the time spent sampling the other tables of the round
+	s := sample{Table: table, Time: time.Now()}
+	database, name := splitTable(table)
+	if err := connect.QueryRow(fmt.Sprintf("SELECT COUNT() FROM %s", table)).Scan(&s.Rows); err != nil {
+		return s, fmt.Errorf("failed to count rows: %v", err)
+	}
+	if err := connect.QueryRow("SELECT COUNT(), sum(bytes_on_disk) FROM system.parts WHERE database = ? AND table = ? AND active", database, name).Scan(&s.Parts, &s.Bytes); err != nil {
+		return s, fmt.Errorf("failed to get parts: %v", err)
+	}
+	if err := connect.QueryRow("SELECT COUNT() FROM system.merges WHERE database = ? AND table = ?", database, name).Scan(&s.Merges); err != nil {
+		return s, fmt.Errorf("failed to get merges: %v", err)
+	}
+	if err := connect.QueryRow("SELECT COUNT() FROM system.mutations WHERE database = ? AND table = ? AND NOT is_done", database, name).Scan(&s.Mutations); err != nil {
+		return s, fmt.Errorf("failed to get mutations: %v", err)
+	}
+	return s, nil
+}
+
+// derive sets the insert and remove rates of s from the previous sample of its table,
+// a drop of the row count being rows removed by TTL or mutations, and annotates it
+// with the events of system.part_log in between.
+func derive(connect *sql.DB, s *sample) {
+	prev, ok := previous[s.Table]
+	previous[s.Table] = *s
+	if !ok {
+		return
+	}
+	seconds := s.Time.Sub(prev.Time).Seconds()
+	if seconds <= 0 {
+		return
+	}
+	if s.Rows >= prev.Rows {
+		s.InsertRate = float64(s.Rows-prev.Rows) / seconds
+	} else {
+		s.RemoveRate = float64(prev.Rows-s.Rows) / seconds
+	}
+	if partLog {
+		events, err := partLogEvents(connect, s.Table, prev.Time, s.Time)
+		if err != nil {
+			klog.Errorf("failed to query system.part_log: %v", err)
+		}
+		s.Events = events
+	}
+}
+
+// partLogEvents returns the mutations and TTL merges of a table between from and to,
+// e.g. "mutation:3" for three mutated parts.
+func partLogEvents(connect *sql.DB, table string, from, to time.Time) ([]string, error) {
+	database, name := splitTable(table)
+	rows, err := connect.Query(`SELECT if(event_type = 'MutatePart', 'mutation', 'ttl_merge') AS event, COUNT()
+		FROM system.part_log
+		WHERE database = ? AND table = ? AND event_time > toDateTime(?) AND event_time <= toDateTime(?)
+			AND (event_type = 'MutatePart' OR (event_type = 'MergeParts' AND merge_reason = 'TTLDeleteMerge'))
+		GROUP BY event ORDER BY event`, database, name, from.Unix(), to.Unix())
 	if err != nil {
-		klog.Fatal(err)
+		return nil, err
 	}
 	defer rows.Close()
-
-	// only one row under default k8s deployment
+	var events []string
 	for rows.Next() {
-		var (
-			count uint64
-		)
-		if err := rows.Scan(&count); err != nil {
-			klog.Fatal(err)
+		var event string
+		var count uint64
+		if err := rows.Scan(&event, &count); err != nil {
+			return nil, err
 		}
-		fmt.Printf("[clickhouse][#records] %d\n", count)
-		countSeries = append(countSeries, count)
+		events = append(events, fmt.Sprintf("%s:%d", event, count))
 	}
+	return events, rows.Err()
 }
 
-func writeToFile(data []uint64) {
-	f, err := os.Create("count.csv")
-	if err != nil {
-		klog.Fatal(err)
+// splitTable splits database.table, the database defaulting to default.
+func splitTable(table string) (string, string) {
+	if i := strings.Index(table, "."); i >= 0 {
+		return table[:i], table[i+1:]
 	}
-	defer f.Close()
-	for i, value := range data {
-		f.WriteString(fmt.Sprintf("%d, %d\n", i, value))
+	return "default", table
+}
+
+// logFunc samples every table, each sample stamped with the time its queries ran.
+func logFunc(connect *sql.DB) {
+	for _, table := range tables {
+		s, err := takeSample(connect, table)
+		if err != nil {
+			klog.Errorf("failed to sample %s: %v", table, err)
+			continue
+		}
+		derive(connect, &s)
+		fmt.Printf("[clickhouse][%s] rows: %d, bytes: %d, parts: %d, merges: %d, mutations: %d, inserted: %.1f rows/s, removed: %.1f rows/s %s\n",
+			s.Table, s.Rows, s.Bytes, s.Parts, s.Merges, s.Mutations, s.InsertRate, s.RemoveRate, strings.Join(s.Events, " "))
+		if err := sink.write(s); err != nil {
+			klog.Errorf("failed to write sample: %v", err)
+		}
 	}
 }
 
 func SetupCloseHandler() {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		writeToFile(countSeries)
+		if err := sink.close(); err != nil {
+			klog.Error(err)
+		}
 		os.Exit(0)
 	}()
 }
 
 func main() {
-	connect, err := sql.Open("clickhouse", "tcp://localhost:9000?debug=true&username=clickhouse_operator&password=clickhouse_operator_password")
+	// example: sample the flows table and the pod view of a ClickHouse server at
+	// 10.0.0.1 every 5 seconds into flows.jsonl
+	// go run . -h 10.0.0.1 -t default.flows -t default.flows_pod_view -interval 5s -format jsonl -o flows.jsonl
+	// or over TLS to the secure port 9440, verifying the server with a local CA
+	// go run . -h 10.0.0.1 -tls -tls-ca ca.pem
+	flag.StringVar(&host, "h", "localhost", "ClickHouse host")
+	flag.IntVar(&port, "port", 9000, "ClickHouse native protocol port")
+	flag.StringVar(&username, "username", "clickhouse_operator", "ClickHouse username")
+	flag.StringVar(&password, "password", "clickhouse_operator_password", "ClickHouse password")
+	flag.Var(&tables, "t", "tables to sample as database.table, comma separated or repeated (default default.flows)")
+	flag.DurationVar(&interval, "interval", 10*time.Second, "sampling interval, a round taking longer delays the next one")
+	flag.StringVar(&format, "format", "csv", "format of the samples: csv or jsonl")
+	flag.BoolVar(&partLog, "part-log", true, "annotate samples with the mutations and TTL merges in system.part_log, which must be enabled on the server")
+	flag.StringVar(&outputPath, "o", "", "file the samples are appended to as soon as they are taken (default count.<format>)")
+	registerTLSFlags()
+	flag.Parse()
+	if len(tables) == 0 {
+		tables = tableList{"default.flows"}
+	}
+	if interval <= 0 {
+		klog.Fatal("the sampling interval must be positive")
+	}
+	if outputPath == "" {
+		outputPath = "count." + format
+	}
+
+	var err error
+	sink, err = openSink(outputPath, format)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	tlsParams, err := tlsParameters()
+	if err != nil {
+		klog.Fatal(err)
+	}
+	dsn := fmt.Sprintf("tcp://%s?debug=true&username=%s&password=%s%s",
+		net.JoinHostPort(host, strconv.Itoa(port)), url.QueryEscape(username), url.QueryEscape(password), tlsParams)
+	connect, err := sql.Open("clickhouse", dsn)
 	if err != nil {
 		klog.Fatal(err)
 	}
@@ -69,10 +206,10 @@ func main() {
 	}
 	SetupCloseHandler()
 
-	logTicker := time.NewTicker(10 * time.Second)
+	// rounds run one after the other, the ticker drops the ticks missed by a slow round
+	logTicker := time.NewTicker(interval)
 	for {
+		logFunc(connect)
 		<-logTicker.C
-		go logFunc(connect)
 	}
-
 }
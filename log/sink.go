@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sample is a snapshot of a table taken by the counter.
+type sample struct {
+	Time      time.Time `json:"time"`
+	Table     string    `json:"table"`
+	Rows      uint64    `json:"rows"`
+	Bytes     uint64    `json:"bytes"`
+	Parts     uint64    `json:"parts"`
+	Merges    uint64    `json:"merges"`
+	Mutations uint64    `json:"mutations"`
+	// InsertRate and RemoveRate are the rows per second added and removed since the
+	// previous sample of the table, derived from the change of the row count.
+	InsertRate float64 `json:"insertRate"`
+	RemoveRate float64 `json:"removeRate"`
+	// Events are the mutations and TTL merges of the table logged in system.part_log
+	// since the previous sample.
+	Events []string `json:"events,omitempty"`
+}
+
+var csvHeader = []string{"time", "table", "rows", "bytes", "parts", "merges", "mutations", "insert_rate", "remove_rate", "events"}
+
+func (s sample) csvRecord() []string {
+	return []string{
+		s.Time.Format(time.RFC3339Nano), s.Table, strconv.FormatUint(s.Rows, 10), strconv.FormatUint(s.Bytes, 10),
+		strconv.FormatUint(s.Parts, 10), strconv.FormatUint(s.Merges, 10), strconv.FormatUint(s.Mutations, 10),
+		strconv.FormatFloat(s.InsertRate, 'f', -1, 64), strconv.FormatFloat(s.RemoveRate, 'f', -1, 64), strings.Join(s.Events, ";"),
+	}
+}
+
+// sampleSink appends every sample to a CSV or JSONL file and syncs it to disk right away,
+// so that the series survives a crash of the counter.
+type sampleSink struct {
+	mutex  sync.Mutex
+	file   *os.File
+	format string
+}
+
+// openSink opens path for appending samples in format, csv or jsonl. The CSV header is
+// written to new files only, and an existing CSV file with another header is refused
+// rather than mixing two layouts in one series.
+func openSink(path, format string) (*sampleSink, error) {
+	if format != "csv" && format != "jsonl" {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &sampleSink{file: f, format: format}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if format == "csv" && info.Size() == 0 {
+		if err := s.writeCSV(csvHeader); err != nil {
+			f.Close()
+			return nil, err
+		}
+	} else if format == "csv" {
+		if err := checkCSVHeader(path); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// checkCSVHeader returns an error unless the first line of the CSV file at path is
+// the header of the samples.
+func checkCSVHeader(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read the header of %s: %v", path, err)
+	}
+	if strings.Join(header, ",") != strings.Join(csvHeader, ",") {
+		return fmt.Errorf("%s has the header %q instead of %q, choose another output file", path, strings.Join(header, ","), strings.Join(csvHeader, ","))
+	}
+	return nil
+}
+
+func (s *sampleSink) write(sample sample) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.format == "csv" {
+		return s.writeCSV(sample.csvRecord())
+	}
+	line, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *sampleSink) writeCSV(record []string) error {
+	w := csv.NewWriter(s.file)
+	if err := w.Write(record); err != nil {
+		return err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *sampleSink) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readLines returns the lines of the file at path.
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestOpenSinkCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "count.csv")
+	for i := 0; i < 2; i++ {
+		// the second sink appends to the file of the first one
+		sink, err := openSink(path, "csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.write(sample{Time: time.Unix(1646128800, 0).UTC(), Table: "flows", Rows: uint64(i)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := readLines(t, path)
+	if len(lines) != 3 || lines[0] != strings.Join(csvHeader, ",") {
+		t.Fatalf("expected the header and 2 samples, got %q", lines)
+	}
+	if !strings.HasPrefix(lines[2], "2022-03-01T10:00:00Z,flows,1,") {
+		t.Errorf("unexpected sample %q", lines[2])
+	}
+}
+
+func TestOpenSinkRefusesOtherHeader(t *testing.T) {
+	// the output of the counter before the samples had a header
+	path := filepath.Join(t.TempDir(), "count.csv")
+	if err := os.WriteFile(path, []byte("0, 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openSink(path, "csv"); err == nil || !strings.Contains(err.Error(), "choose another output file") {
+		t.Fatalf("expected an error for the file with another header, got %v", err)
+	}
+	if lines := readLines(t, path); len(lines) != 1 || lines[0] != "0, 0" {
+		t.Errorf("expected the file to be left unchanged, got %q", lines)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+
+	"clickhouse/common/connection"
+	"clickhouse/common/tlsconfig"
+)
+
+var tlsFlags tlsconfig.Flags
+
+// registerTLSFlags defines the flags of the TLS connection to ClickHouse.
+func registerTLSFlags() {
+	tlsFlags.Register(flag.CommandLine, "connect over TLS, to the secure port 9440 unless -port is set")
+}
+
+// tlsParameters registers the TLS configuration with the driver and returns the DSN
+// parameters selecting it, switching to the secure port if -port is not set. Returns
+// an empty string without -tls.
+func tlsParameters() (string, error) {
+	if !tlsFlags.Enabled {
+		return "", nil
+	}
+	portSet := false
+	flag.Visit(func(f *flag.Flag) { portSet = portSet || f.Name == "port" })
+	if !portSet {
+		port = 9440
+	}
+	config, err := tlsFlags.Config()
+	if err != nil {
+		return "", err
+	}
+	return connection.TLSParameters(config)
+}
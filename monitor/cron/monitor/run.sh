@@ -1,4 +1,7 @@
 #!/bin/bash
 
+# cron does not pass the environment of the container to its jobs, the flags of the
+# monitor are set in the crontab, e.g. MONITOR_ARGS="-tls -tls-ca=/etc/clickhouse-monitor/certs/ca.pem"
+{ echo "MONITOR_ARGS=${MONITOR_ARGS}"; cat /monitor/monitor-cron; } | crontab -
 /etc/init.d/cron start
 /bin/bash /entrypoint.sh
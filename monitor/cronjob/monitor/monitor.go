@@ -2,13 +2,21 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
 	"time"
 
-	"github.com/ClickHouse/clickhouse-go"
 	"k8s.io/klog/v2"
+
+	"clickhouse/common/connection"
+	"clickhouse/common/tlsconfig"
 )
 
+// The -tls flags of the connection to Clickhouse.
+var tlsFlags tlsconfig.Flags
+
 const (
 	// The limit of storage used by the clickhouse in byte. The default value is 1G.
 	limitedSpace = 1024 * 1024 * 1024
@@ -19,85 +27,145 @@ const (
 
 	// Connection to Clickhouse timeout if if fails for 5 minutes
 	connectionTimeout = 5 * time.Minute
-	// Retry connection to Clickhouse every 5 seconds if it fails
-	connectionWait = 5 * time.Second
+	// The first retry of the connection to Clickhouse is after 1 second, the wait doubles up to 30 seconds
+	connectionInitialWait = time.Second
+	connectionMaxWait     = 30 * time.Second
+	// Timeout of a connection attempt
+	connectionAttemptTimeout = 10 * time.Second
 )
 
-func monitorMemory(connect *sql.DB) {
-	rows, err := connect.Query("SELECT total_bytes FROM system.tables WHERE database='default' AND name='antrea'")
-	if err != nil {
-		klog.Info(err)
+// The exit codes of the monitor, which the terminated state of the Job pods reports.
+const (
+	exitConnectFailure = 2
+	exitQueryFailure   = 3
+	exitEvictFailure   = 4
+)
+
+// connectError is returned when the monitor cannot connect to Clickhouse.
+type connectError struct {
+	err error
+}
+
+func (e *connectError) Error() string {
+	return fmt.Sprintf("clickhouse connection failed: %v", e.err)
+}
+
+func (e *connectError) Unwrap() error {
+	return e.err
+}
+
+// queryError is returned when a query reading the storage usage or the records fails.
+type queryError struct {
+	query string
+	err   error
+}
+
+func (e *queryError) Error() string {
+	return fmt.Sprintf("query %q failed: %v", e.query, e.err)
+}
+
+func (e *queryError) Unwrap() error {
+	return e.err
+}
+
+// evictError is returned when the deletion of the old records fails.
+type evictError struct {
+	rows uint64
+	err  error
+}
+
+func (e *evictError) Error() string {
+	return fmt.Sprintf("failed to delete %d records: %v", e.rows, e.err)
+}
+
+func (e *evictError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code of the monitor for an error.
+func exitCode(err error) int {
+	var connectErr *connectError
+	var queryErr *queryError
+	var evictErr *evictError
+	switch {
+	case errors.As(err, &connectErr):
+		return exitConnectFailure
+	case errors.As(err, &queryErr):
+		return exitQueryFailure
+	case errors.As(err, &evictErr):
+		return exitEvictFailure
+	default:
+		return 1
+	}
+}
+
+func monitorMemory(connect *sql.DB) error {
+	query := "SELECT total_bytes FROM system.tables WHERE database='default' AND name='antrea'"
+	var usedSpace uint64
+	if err := connect.QueryRow(query).Scan(&usedSpace); err != nil {
+		if err == sql.ErrNoRows {
+			err = fmt.Errorf("table default.antrea not found")
+		}
+		return &queryError{query: query, err: err}
 	}
-	defer rows.Close()
+	usagePercentage := float64(usedSpace) / float64(limitedSpace)
+	fmt.Printf("Memory usage: %f\n", usagePercentage)
 
-	// only one row
-	for rows.Next() {
-		var usedSpace uint64
-		if err := rows.Scan(&usedSpace); err != nil {
-			klog.Info(err)
+	if usagePercentage > threshold {
+		deleteRowNum, err := getDeleteRowNum(connect)
+		if err != nil {
+			return err
 		}
-		usagePercentage := float64(usedSpace) / float64(limitedSpace)
-		fmt.Printf("Memory usage: %f\n", usagePercentage)
-
-		if usagePercentage > threshold {
-			alterCommand := fmt.Sprintf("ALTER TABLE default.antrea DELETE WHERE id IN (SELECT id FROM default.antrea ORDER BY createTime LIMIT %d)", getDeleteRowNum(connect))
-			if _, err := connect.Exec(alterCommand); err != nil {
-				klog.Info(err)
-			}
+		alterCommand := fmt.Sprintf("ALTER TABLE default.antrea DELETE WHERE id IN (SELECT id FROM default.antrea ORDER BY createTime LIMIT %d)", deleteRowNum)
+		if _, err := connect.Exec(alterCommand); err != nil {
+			return &evictError{rows: deleteRowNum, err: err}
 		}
 	}
+	return nil
 }
 
-func getDeleteRowNum(connect *sql.DB) uint64 {
-	rows, err := connect.Query("SELECT COUNT() FROM default.antrea")
-	if err != nil {
-		klog.Info(err)
-	}
-	defer rows.Close()
-	var deleteRowNum uint64
-	// only one row
-	for rows.Next() {
-		var count uint64
-		if err := rows.Scan(&count); err != nil {
-			klog.Info(err)
-		}
-		deleteRowNum = uint64(float64(count) * deletePercentage)
+func getDeleteRowNum(connect *sql.DB) (uint64, error) {
+	query := "SELECT COUNT() FROM default.antrea"
+	var count uint64
+	if err := connect.QueryRow(query).Scan(&count); err != nil {
+		return 0, &queryError{query: query, err: err}
 	}
-	return deleteRowNum
+	return uint64(float64(count) * deletePercentage), nil
 }
 
 func connectLoop() (*sql.DB, error) {
-	ticker := time.NewTicker(connectionWait)
-	defer ticker.Stop()
-
-	timeoutExceeded := time.After(connectionTimeout)
-	for {
-		select {
-		case <-timeoutExceeded:
-			return nil, fmt.Errorf("clickhouse connection failed after %s", connectionTimeout)
-
-		case <-ticker.C:
-			connect, err := sql.Open("clickhouse", "tcp://clickhouse-clickhouse.flow-visibility.svc.cluster.local:9000?debug=true&username=clickhouse_operator&password=clickhouse_operator_password")
-			if err != nil {
-				klog.Info("failed to connect to clickhouse: ", err)
-			}
-			if err := connect.Ping(); err != nil {
-				if exception, ok := err.(*clickhouse.Exception); ok {
-					fmt.Printf("[%d] %s \n%s\n", exception.Code, exception.Message, exception.StackTrace)
-				} else {
-					fmt.Println(err)
-				}
-			} else {
-				return connect, nil
-			}
-		}
+	port, tlsParams, err := connection.NativePortAndTLSParameters(&tlsFlags)
+	if err != nil {
+		return nil, &connectError{err: err}
+	}
+	dsn := fmt.Sprintf("tcp://clickhouse-clickhouse.flow-visibility.svc.cluster.local:%d?debug=true&username=clickhouse_operator&password=clickhouse_operator_password%s", port, tlsParams)
+	connect, err := connection.WithBackoff(dsn, connection.Backoff{
+		Initial:        connectionInitialWait,
+		Max:            connectionMaxWait,
+		AttemptTimeout: connectionAttemptTimeout,
+		Timeout:        connectionTimeout,
+	})
+	if err != nil {
+		return nil, &connectError{err: err}
 	}
+	return connect, nil
 }
 
-func main() {
+func run() error {
 	connect, err := connectLoop()
 	if err != nil {
-		klog.Fatal(err)
+		return err
+	}
+	defer connect.Close()
+	return monitorMemory(connect)
+}
+
+func main() {
+	tlsFlags.Register(flag.CommandLine, "connect over TLS, to the secure port 9440")
+	flag.Parse()
+	if err := run(); err != nil {
+		klog.Error(err)
+		klog.Flush()
+		os.Exit(exitCode(err))
 	}
-	monitorMemory(connect)
 }
@@ -18,23 +18,24 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/ClickHouse/clickhouse-go"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
+
+	"clickhouse/common/connection"
+	"clickhouse/common/storage"
 )
 
 const (
-	// The storage percentage at which the monitor starts to delete old records. By default, if the storage usage is larger than 50%, it starts to delete the old records.
-	threshold = 0.5
 	// The percentage of records in clickhouse will be deleted when the storage is above threshold.
 	deletePercentage = 0.5
 
@@ -42,16 +43,67 @@ const (
 	skipRoundsNum = 3
 )
 
+var (
+	// The storage percentage at which the monitor starts to delete old records. By default, if the storage usage is larger than 50%, it starts to delete the old records.
+	threshold = 0.5
+	// The table of the default database the monitor deletes records from.
+	monitoredTable = "flows"
+	// The address of the Clickhouse native protocol.
+	clickhouseAddr string
+	// mode is delete to delete old records, or ttl to adapt the TTL of the flows table and its views.
+	mode string
+	// The bounds of the TTL in ttl mode.
+	minTTL, maxTTL time.Duration
+	// The storage percentage below which the monitor lengthens the TTL in ttl mode.
+	lowerThreshold float64
+	// The factor the TTL is shortened by, and lengthened by its inverse, in ttl mode.
+	ttlFactor float64
+)
+
 func main() {
+	flag.StringVar(&clickhouseAddr, "addr", "clickhouse-clickhouse.flow-visibility.svc.cluster.local:9000", "address of the Clickhouse native protocol")
+	flag.StringVar(&mode, "mode", "delete", "delete: delete old records when the storage is above the threshold, ttl: adapt the TTL of flows and its views instead, deleting records when the TTL is at min-ttl")
+	flag.Float64Var(&threshold, "threshold", threshold, "storage usage above which the monitor deletes records or shortens the TTL")
+	flag.StringVar(&monitoredTable, "table", monitoredTable, "table of the default database the oldest records are deleted from in delete mode")
+	flag.DurationVar(&minTTL, "min-ttl", 10*time.Minute, "shortest TTL in ttl mode")
+	flag.DurationVar(&maxTTL, "max-ttl", time.Hour, "longest TTL in ttl mode")
+	flag.Float64Var(&lowerThreshold, "lower-threshold", 0.3, "storage usage below which the TTL is lengthened in ttl mode")
+	flag.Float64Var(&ttlFactor, "ttl-factor", 0.5, "factor the TTL is shortened by in ttl mode, it is lengthened by the inverse")
+	registerTLSFlags()
+	flag.Parse()
+	addrSet := false
+	flag.Visit(func(f *flag.Flag) { addrSet = addrSet || f.Name == "addr" })
+	if tlsFlags.Enabled && !addrSet {
+		clickhouseAddr = "clickhouse-clickhouse.flow-visibility.svc.cluster.local:9440"
+	}
+	if mode != "delete" && mode != "ttl" {
+		klog.Fatalf("unknown mode %q", mode)
+	}
+	if mode == "ttl" && (minTTL < time.Second || maxTTL < minTTL || ttlFactor <= 0 || ttlFactor >= 1 || lowerThreshold >= threshold) {
+		klog.Fatal("ttl mode requires 1s <= min-ttl <= max-ttl, 0 < ttl-factor < 1 and lower-threshold < threshold")
+	}
+
 	// The monitor stops working for several rounds after a deletion
 	// as the release of the memory space for clickhouse MergeTree engine requires time
-	if !skipRound() {
+	skip := false
+	if clientset, err := newClientset(); err != nil {
+		klog.Infof("error in finding last monitor job: %v", err)
+	} else {
+		skip = skipRound(clientset)
+	}
+	if !skip {
 		connect, err := connectLoop()
 		if err != nil {
 			klog.Info(err)
 			return
 		}
-		deleted := monitorMemory(connect)
+		store := storage.NewClickHouseStore(connect)
+		var deleted bool
+		if mode == "ttl" {
+			deleted = adaptTTL(connect, store)
+		} else if deleted, err = monitorMemory(store); err != nil {
+			klog.Error(err)
+		}
 		if deleted {
 			klog.Infof("Number of rounds to be skipped: %d", skipRoundsNum)
 		} else {
@@ -63,8 +115,8 @@ func main() {
 // Checks the k8s log for the number of rounds to skip.
 // Returns true when the monitor needs to skip more rounds and logs the number of rounds to skip for next time,
 // Otherwise returns false.
-func skipRound() bool {
-	logString, err := getPodLogs()
+func skipRound(clientset kubernetes.Interface) bool {
+	logString, err := getPodLogs(clientset)
 	if err != nil {
 		klog.Infof("error in finding last monitor job: %v", err)
 		return false
@@ -72,11 +124,11 @@ func skipRound() bool {
 	// reads the number of rounds requires to be skipped
 	logs := strings.Split(logString, "Number of rounds to be skipped: ")
 	if len(logs) < 2 {
-		klog.Infof("error in finding last monitor job: %v", err)
+		klog.Info("error in finding last monitor job: no number of rounds to be skipped in the log")
 		return false
 	}
-	lines := strings.Split(logs[1], "\n")
-	remainingRoundsNum, convErr := strconv.Atoi(lines[0])
+	lines := strings.Split(logs[len(logs)-1], "\n")
+	remainingRoundsNum, convErr := strconv.Atoi(strings.TrimSpace(lines[0]))
 	if convErr != nil {
 		klog.Infof("error in finding last monitor job: %v", convErr)
 		return false
@@ -88,131 +140,82 @@ func skipRound() bool {
 	return false
 }
 
-// Gets pod logs from the Clickhouse monitor job
-func getPodLogs() (string, error) {
-	var logString string
-	namespace := "flow-visibility"
-	podLogOpts := corev1.PodLogOptions{}
+// Creates the clientset of the cluster the monitor runs in.
+func newClientset() (kubernetes.Interface, error) {
 	config, err := rest.InClusterConfig()
-	listOptions := metav1.ListOptions{
-		LabelSelector: "app=clickhouse-monitor",
-	}
 	if err != nil {
-		return logString, fmt.Errorf("error in getting config: %v", err)
+		return nil, fmt.Errorf("error in getting config: %v", err)
 	}
-	// creates the clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		return logString, fmt.Errorf("error in getting access to K8S: %v", err)
+		return nil, fmt.Errorf("error in getting access to K8S: %v", err)
+	}
+	return clientset, nil
+}
+
+// Opens the log stream of a pod. A variable so that tests can provide the logs,
+// which the fake clientset does not support.
+var streamPodLogs = func(clientset kubernetes.Interface, namespace, name string) (io.ReadCloser, error) {
+	podLogOpts := corev1.PodLogOptions{}
+	req := clientset.CoreV1().Pods(namespace).GetLogs(name, &podLogOpts)
+	return req.Stream(context.TODO())
+}
+
+// Gets pod logs from the last succeeded Clickhouse monitor job
+func getPodLogs(clientset kubernetes.Interface) (string, error) {
+	namespace := "flow-visibility"
+	listOptions := metav1.ListOptions{
+		LabelSelector: "app=clickhouse-monitor",
 	}
 	// gets Clickhouse monitor pod
 	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), listOptions)
 	if err != nil {
-		return logString, fmt.Errorf("failed to list clickhouse monitor Pods: %v", err)
-	}
-	for _, pod := range pods.Items {
-		// reads logs from the last successful pod
-		if pod.Status.Phase == corev1.PodSucceeded {
-			req := clientset.CoreV1().Pods(namespace).GetLogs(pod.Name, &podLogOpts)
-			podLogs, err := req.Stream(context.TODO())
-			if err != nil {
-				return logString, fmt.Errorf("error in opening stream: %v", err)
-			}
-			defer podLogs.Close()
-
-			buf := new(bytes.Buffer)
-			_, err = io.Copy(buf, podLogs)
-			if err != nil {
-				return logString, fmt.Errorf("error in copy information from podLogs to buf: %v", err)
-			}
-			logString := buf.String()
-			return logString, nil
-		}
+		return "", fmt.Errorf("failed to list clickhouse monitor Pods: %v", err)
 	}
-	return logString, fmt.Errorf("failed to find a succeeded monitor")
-}
-
-// Connects to Clickhouse in a loop
-func connectLoop() (*sql.DB, error) {
-	// Connection to Clickhouse timeout if if fails for 1 minute.
-	connectionTimeout := time.Minute
-	// Retry connection to Clickhouse every 5 seconds if it fails.
-	connectionWait := 5 * time.Second
-
-	ticker := time.NewTicker(connectionWait)
-	defer ticker.Stop()
-
-	timeoutExceeded := time.After(connectionTimeout)
-	for {
-		select {
-		case <-timeoutExceeded:
-			return nil, fmt.Errorf("failed to connect to clickhouse after %s", connectionTimeout)
-
-		case <-ticker.C:
-			// TODO:  use k8s secret to store the username and password
-			connect, err := sql.Open("clickhouse", "tcp://clickhouse-clickhouse.flow-visibility.svc.cluster.local:9000?debug=true&username=clickhouse_operator&password=clickhouse_operator_password")
-			if err != nil {
-				klog.Infof("failed to connect to clickhouse: %v", err)
-			}
-			if err := connect.Ping(); err != nil {
-				if exception, ok := err.(*clickhouse.Exception); ok {
-					klog.Infof("[%d] %s \n", exception.Code, exception.Message)
-				} else {
-					klog.Info(err)
-				}
-			} else {
-				return connect, nil
-			}
+	// reads logs from the last successful pod, the pods are not listed in creation order
+	var last *corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase == corev1.PodSucceeded && (last == nil || last.CreationTimestamp.Before(&pod.CreationTimestamp)) {
+			last = pod
 		}
 	}
-}
-
-// Checks the memory usage in the Clickhouse, deletes records when it exceeds the threshold.
-func monitorMemory(connect *sql.DB) bool {
-	rows, err := connect.Query("SELECT free_space, total_space FROM system.disks")
+	if last == nil {
+		return "", fmt.Errorf("failed to find a succeeded monitor")
+	}
+	podLogs, err := streamPodLogs(clientset, namespace, last.Name)
 	if err != nil {
-		klog.Error(err)
-		return false
+		return "", fmt.Errorf("error in opening stream: %v", err)
 	}
-	defer rows.Close()
+	defer podLogs.Close()
 
-	for rows.Next() {
-		var (
-			freeSpace  uint64
-			totalSpace uint64
-		)
-		if err := rows.Scan(&freeSpace, &totalSpace); err != nil {
-			klog.Error(err)
-			return false
-		}
-		usagePercentage := float64(totalSpace-freeSpace) / float64(totalSpace)
-		klog.Infof("Memory usage: total %d, used: %d, percentage: %f", totalSpace, totalSpace-freeSpace, usagePercentage)
-		if usagePercentage > threshold {
-			alterCommand := fmt.Sprintf("ALTER TABLE default.antrea DELETE WHERE id IN (SELECT id FROM default.antrea LIMIT %d)", getDeleteRowNum(connect))
-			if _, err := connect.Exec(alterCommand); err != nil {
-				klog.Info(err)
-				return false
-			}
-			return true
-		}
+	buf := new(bytes.Buffer)
+	_, err = io.Copy(buf, podLogs)
+	if err != nil {
+		return "", fmt.Errorf("error in copy information from podLogs to buf: %v", err)
 	}
-	return false
+	return buf.String(), nil
 }
 
-func getDeleteRowNum(connect *sql.DB) uint64 {
-	rows, err := connect.Query("SELECT COUNT() FROM default.antrea")
+// Connects to Clickhouse, retrying with an exponential backoff for 1 minute.
+func connectLoop() (*sql.DB, error) {
+	// TODO:  use k8s secret to store the username and password
+	tlsParams, err := tlsParameters()
 	if err != nil {
-		klog.Info(err)
-	}
-	defer rows.Close()
-	var deleteRowNum uint64
-	// only one row
-	for rows.Next() {
-		var count uint64
-		if err := rows.Scan(&count); err != nil {
-			klog.Info(err)
-		}
-		deleteRowNum = uint64(float64(count) * deletePercentage)
+		return nil, err
 	}
-	return deleteRowNum
+	dsn := fmt.Sprintf("tcp://%s?debug=true&username=clickhouse_operator&password=clickhouse_operator_password%s", clickhouseAddr, tlsParams)
+	return connection.WithBackoff(dsn, connection.Backoff{
+		Initial:        time.Second,
+		Max:            15 * time.Second,
+		AttemptTimeout: 10 * time.Second,
+		Timeout:        time.Minute,
+	})
+}
+
+// Checks the storage usage, deletes records when it exceeds the threshold.
+// Returns true when records were deleted.
+func monitorMemory(store storage.Store) (bool, error) {
+	eviction, err := storage.MonitorMemory(store, monitoredTable, threshold, deletePercentage)
+	return eviction != nil, err
 }
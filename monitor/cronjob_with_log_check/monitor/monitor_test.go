@@ -0,0 +1,215 @@
+// Copyright 2022 Antrea Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func monitorPod(name string, phase corev1.PodPhase, created time.Time) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "flow-visibility",
+			Labels:            map[string]string{"app": "clickhouse-monitor"},
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+// fakeLogs makes streamPodLogs return the logs of the pods by name, or an error for
+// the pods without logs, until the end of the test.
+func fakeLogs(t *testing.T, logs map[string]string) {
+	stream := streamPodLogs
+	t.Cleanup(func() { streamPodLogs = stream })
+	streamPodLogs = func(clientset kubernetes.Interface, namespace, name string) (io.ReadCloser, error) {
+		log, ok := logs[name]
+		if !ok {
+			return nil, fmt.Errorf("container of pod %s not found", name)
+		}
+		return ioutil.NopCloser(strings.NewReader(log)), nil
+	}
+}
+
+func TestSkipRound(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name     string
+		pods     []runtime.Object
+		logs     map[string]string
+		expected bool
+	}{
+		{
+			name: "no succeeded pods",
+			pods: []runtime.Object{
+				monitorPod("monitor-1", corev1.PodFailed, now.Add(-2*time.Minute)),
+				monitorPod("monitor-2", corev1.PodRunning, now),
+			},
+			logs: map[string]string{
+				"monitor-1": "I0101 main.go:1] Number of rounds to be skipped: 3\n",
+			},
+			expected: false,
+		},
+		{
+			name: "rounds to skip",
+			pods: []runtime.Object{
+				monitorPod("monitor-1", corev1.PodSucceeded, now),
+			},
+			logs: map[string]string{
+				"monitor-1": "I0101 main.go:1] Memory usage: total 100, used: 60, percentage: 0.600000\nI0101 main.go:2] Number of rounds to be skipped: 3\n",
+			},
+			expected: true,
+		},
+		{
+			name: "no rounds to skip",
+			pods: []runtime.Object{
+				monitorPod("monitor-1", corev1.PodSucceeded, now),
+			},
+			logs: map[string]string{
+				"monitor-1": "I0101 main.go:2] Number of rounds to be skipped: 0\n",
+			},
+			expected: false,
+		},
+		{
+			name: "multiple succeeded pods, the last one skips",
+			pods: []runtime.Object{
+				monitorPod("monitor-b", corev1.PodSucceeded, now.Add(-2*time.Minute)),
+				monitorPod("monitor-a", corev1.PodSucceeded, now.Add(-time.Minute)),
+				monitorPod("monitor-c", corev1.PodFailed, now),
+			},
+			logs: map[string]string{
+				"monitor-a": "I0101 main.go:2] Number of rounds to be skipped: 2\n",
+				"monitor-b": "I0101 main.go:2] Number of rounds to be skipped: 0\n",
+			},
+			expected: true,
+		},
+		{
+			name: "multiple succeeded pods, the last one does not skip",
+			pods: []runtime.Object{
+				monitorPod("monitor-a", corev1.PodSucceeded, now.Add(-2*time.Minute)),
+				monitorPod("monitor-b", corev1.PodSucceeded, now.Add(-time.Minute)),
+			},
+			logs: map[string]string{
+				"monitor-a": "I0101 main.go:2] Number of rounds to be skipped: 2\n",
+				"monitor-b": "I0101 main.go:2] Number of rounds to be skipped: 1\nI0101 main.go:2] Number of rounds to be skipped: 0\n",
+			},
+			expected: false,
+		},
+		{
+			name: "no number of rounds in the log",
+			pods: []runtime.Object{
+				monitorPod("monitor-1", corev1.PodSucceeded, now),
+			},
+			logs: map[string]string{
+				"monitor-1": "I0101 main.go:1] failed to connect to clickhouse after 1m0s\n",
+			},
+			expected: false,
+		},
+		{
+			name: "malformed number of rounds",
+			pods: []runtime.Object{
+				monitorPod("monitor-1", corev1.PodSucceeded, now),
+			},
+			logs: map[string]string{
+				"monitor-1": "I0101 main.go:2] Number of rounds to be skipped: three\n",
+			},
+			expected: false,
+		},
+		{
+			name: "truncated log",
+			pods: []runtime.Object{
+				monitorPod("monitor-1", corev1.PodSucceeded, now),
+			},
+			logs: map[string]string{
+				"monitor-1": "I0101 main.go:2] Number of rounds to be skipped: ",
+			},
+			expected: false,
+		},
+		{
+			name: "log retrieval failure",
+			pods: []runtime.Object{
+				monitorPod("monitor-1", corev1.PodSucceeded, now),
+			},
+			logs:     map[string]string{},
+			expected: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeLogs(t, tc.logs)
+			clientset := fake.NewSimpleClientset(tc.pods...)
+			if skip := skipRound(clientset); skip != tc.expected {
+				t.Errorf("expected skipRound to return %t, got %t", tc.expected, skip)
+			}
+		})
+	}
+}
+
+func TestGetPodLogs(t *testing.T) {
+	now := time.Now()
+	fakeLogs(t, map[string]string{
+		"monitor-1": "first\n",
+		"monitor-2": "second\n",
+	})
+
+	t.Run("last succeeded pod of the monitor", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(
+			monitorPod("monitor-1", corev1.PodSucceeded, now.Add(-time.Minute)),
+			monitorPod("monitor-2", corev1.PodSucceeded, now),
+		)
+		// a pod of another application, which the label selector excludes
+		other := monitorPod("monitor-3", corev1.PodSucceeded, now.Add(time.Minute))
+		other.Labels = map[string]string{"app": "clickhouse"}
+		clientset.Tracker().Add(other)
+		logs, err := getPodLogs(clientset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if logs != "second\n" {
+			t.Errorf("expected the logs of monitor-2, got %q", logs)
+		}
+	})
+
+	t.Run("pods in another namespace", func(t *testing.T) {
+		pod := monitorPod("monitor-1", corev1.PodSucceeded, now)
+		pod.Namespace = "default"
+		if _, err := getPodLogs(fake.NewSimpleClientset(pod)); err == nil {
+			t.Error("expected an error without monitor pods in flow-visibility")
+		}
+	})
+
+	t.Run("list failure", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(monitorPod("monitor-1", corev1.PodSucceeded, now))
+		clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, fmt.Errorf("forbidden")
+		})
+		_, err := getPodLogs(clientset)
+		if err == nil || !strings.Contains(err.Error(), "failed to list clickhouse monitor Pods") {
+			t.Errorf("expected a list error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 Antrea Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"clickhouse/common/storage"
+	"clickhouse/common/storage/storagetest"
+)
+
+// simulateRounds runs the rounds of the CronJob on a growing store, skipping
+// skipRounds rounds after a deletion, and returns the rounds of the deletions and
+// whether a deletion was issued while the previous one was still running.
+func simulateRounds(t *testing.T, store *storagetest.MemStore, rounds, skipRounds int) ([]int, bool) {
+	var deletions []int
+	overlapped := false
+	skipping := 0
+	for round := 0; round < rounds; round++ {
+		if skipping > 0 {
+			skipping--
+		} else {
+			pending := len(store.Mutations) > 0 && store.Mutations[len(store.Mutations)-1].Remaining > 0
+			deleted, err := monitorMemory(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted {
+				deletions = append(deletions, round)
+				overlapped = overlapped || pending
+				skipping = skipRounds
+			}
+		}
+		store.Tick()
+	}
+	return deletions, overlapped
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	newStore := func() *storagetest.MemStore {
+		store := storagetest.NewMemStore(10000, 2)
+		store.AddTable(monitoredTable, 4000, 1, 500)
+		return store
+	}
+
+	// skipping the rounds waits for the space of a deletion to be released
+	store := newStore()
+	deletions, overlapped := simulateRounds(t, store, 30, skipRoundsNum)
+	if len(deletions) < 2 || overlapped {
+		t.Errorf("expected several deletions, each after the previous one is done, got %v (overlapped: %t)", deletions, overlapped)
+	}
+	for i := 1; i < len(deletions); i++ {
+		if deletions[i]-deletions[i-1] <= skipRoundsNum {
+			t.Errorf("expected %d rounds skipped after a deletion, got deletions in rounds %v", skipRoundsNum, deletions)
+		}
+	}
+	if usage, _ := storage.Usage(store); usage > 0.8 {
+		t.Errorf("expected the usage to stay bounded, got %f", usage)
+	}
+
+	// without skipping, the monitor deletes again before the space is released
+	store = newStore()
+	deletions, overlapped = simulateRounds(t, store, 30, 0)
+	if !overlapped {
+		t.Errorf("expected overlapping deletions without skipped rounds, got deletions in rounds %v", deletions)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2022 Antrea Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+
+	"clickhouse/common/connection"
+	"clickhouse/common/tlsconfig"
+)
+
+// The -tls flags of the connection to Clickhouse.
+var tlsFlags tlsconfig.Flags
+
+func registerTLSFlags() {
+	tlsFlags.Register(flag.CommandLine, "connect over TLS, to the secure port 9440 unless -addr is set")
+}
+
+// Registers the TLS configuration of the flags with the driver and returns the DSN
+// parameters selecting it, or an empty string without -tls.
+func tlsParameters() (string, error) {
+	config, err := tlsFlags.Config()
+	if err != nil {
+		return "", err
+	}
+	return connection.TLSParameters(config)
+}
@@ -0,0 +1,221 @@
+// Copyright 2022 Antrea Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"clickhouse/common/storage"
+)
+
+// The views of the flows table, whose TTL follows the TTL of flows.
+var flowsViews = []string{"flows_pod_view", "flows_node_view", "flows_policy_view"}
+
+var ttlExpression = regexp.MustCompile(`TTL timeInserted \+ toInterval(Second|Minute|Hour|Day|Week)\((\d+)\)`)
+
+var ttlUnits = map[string]time.Duration{
+	"Second": time.Second,
+	"Minute": time.Minute,
+	"Hour":   time.Hour,
+	"Day":    24 * time.Hour,
+	"Week":   7 * 24 * time.Hour,
+}
+
+// Adapts the TTL of the flows table and its views to the storage usage: shortens it when the usage is above
+// the threshold and lengthens it when the usage is below the lower threshold, within the TTL bounds.
+// Every change is recorded with its reason in default.flows_ttl_history. The TTL is only shortened again once
+// the previous shortening took effect, until then the monitor records that it waits. When the usage is above
+// the threshold with the shortest TTL already, the oldest records are deleted like in delete mode.
+// Returns true when the TTL was shortened or records were deleted.
+func adaptTTL(connect *sql.DB, store storage.Store) bool {
+	usage, err := storage.Usage(store)
+	if err != nil {
+		klog.Error(err)
+		return false
+	}
+	current, err := getTTL(connect)
+	if err != nil {
+		klog.Error(err)
+		return false
+	}
+	klog.Infof("Active TTL: %s, storage usage: %f", current, usage)
+
+	ttl := current
+	var reason string
+	switch {
+	case current < minTTL || current > maxTTL:
+		ttl = clampTTL(current)
+		reason = fmt.Sprintf("TTL %s outside of [%s, %s]", current, minTTL, maxTTL)
+	case usage > threshold && current > minTTL:
+		effective, err := shorteningEffective(connect, current)
+		if err != nil {
+			klog.Error(err)
+			return false
+		}
+		if !effective.IsZero() {
+			reason = fmt.Sprintf("storage usage %f above threshold %f, waiting until %s for the TTL %s to take effect", usage, threshold, effective.Format(time.RFC3339), current)
+			klog.Info(reason)
+			if err := recordTTL(connect, current, current, usage, reason); err != nil {
+				klog.Errorf("failed to record the wait: %v", err)
+			}
+			return false
+		}
+		ttl = clampTTL(time.Duration(float64(current) * ttlFactor))
+		reason = fmt.Sprintf("storage usage %f above threshold %f", usage, threshold)
+	case usage > threshold:
+		klog.Errorf("Storage usage %f above threshold %f with the shortest TTL %s, deleting the oldest records", usage, threshold, minTTL)
+		deleted, err := monitorMemory(store)
+		if err != nil {
+			klog.Error(err)
+		}
+		return deleted
+	case usage < lowerThreshold && current < maxTTL:
+		ttl = clampTTL(time.Duration(float64(current) / ttlFactor))
+		reason = fmt.Sprintf("storage usage %f below lower threshold %f", usage, lowerThreshold)
+	}
+	if ttl == current {
+		return false
+	}
+
+	if err := setTTL(connect, ttl); err != nil {
+		klog.Error(err)
+		return false
+	}
+	klog.Infof("Changed TTL from %s to %s: %s", current, ttl, reason)
+	if err := recordTTL(connect, current, ttl, usage, reason); err != nil {
+		klog.Errorf("failed to record the TTL change: %v", err)
+	}
+	return ttl < current
+}
+
+// Gets the TTL of the flows table, rounded down to whole seconds.
+func getTTL(connect *sql.DB) (time.Duration, error) {
+	var statement string
+	if err := connect.QueryRow("SHOW CREATE TABLE default.flows").Scan(&statement); err != nil {
+		return 0, err
+	}
+	match := ttlExpression.FindStringSubmatch(statement)
+	if match == nil {
+		return 0, fmt.Errorf("failed to find the TTL of default.flows on timeInserted")
+	}
+	n, err := strconv.ParseInt(match[2], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n) * ttlUnits[match[1]], nil
+}
+
+func clampTTL(ttl time.Duration) time.Duration {
+	ttl = ttl.Truncate(time.Second)
+	if ttl < minTTL {
+		return minTTL
+	}
+	if ttl > maxTTL {
+		return maxTTL
+	}
+	return ttl
+}
+
+// Sets the TTL of the flows table and of the inner tables of its materialized views.
+// The TTL is not materialized on the existing parts, which would rewrite all of them: their rows expire at
+// the next TTL merge, at most every merge_with_ttl_timeout of the tables.
+func setTTL(connect *sql.DB, ttl time.Duration) error {
+	tables := []string{"flows"}
+	for _, view := range flowsViews {
+		inner, err := storage.InnerTable(connect, "default", view)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, inner)
+	}
+	for _, table := range tables {
+		alterCommand := fmt.Sprintf("ALTER TABLE default.`%s` MODIFY TTL timeInserted + INTERVAL %d SECOND SETTINGS materialize_ttl_after_modify = 0", table, ttl/time.Second)
+		if _, err := connect.Exec(alterCommand); err != nil {
+			return fmt.Errorf("failed to set the TTL of %s: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// Returns the time at which the last shortening of the TTL recorded in default.flows_ttl_history takes
+// effect, or zero if it already did. The TTL is not materialized: the rows inserted before the shortening
+// keep the previous TTL and expire at the latest the previous TTL after the change. It took effect earlier
+// if no row is older than the current TTL anymore.
+func shorteningEffective(connect *sql.DB, current time.Duration) (time.Time, error) {
+	var exists uint8
+	if err := connect.QueryRow("EXISTS TABLE default.flows_ttl_history").Scan(&exists); err != nil {
+		return time.Time{}, err
+	}
+	if exists == 0 {
+		return time.Time{}, nil
+	}
+	var changed time.Time
+	var previousSeconds uint64
+	err := connect.QueryRow("SELECT timeChanged, previousTTLSeconds FROM default.flows_ttl_history WHERE ttlSeconds < previousTTLSeconds ORDER BY timeChanged DESC LIMIT 1").Scan(&changed, &previousSeconds)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	effective := changed.Add(time.Duration(previousSeconds) * time.Second)
+	if !time.Now().Before(effective) {
+		return time.Time{}, nil
+	}
+	var expired uint64
+	if err := connect.QueryRow(fmt.Sprintf("SELECT COUNT() FROM default.flows WHERE timeInserted < now() - INTERVAL %d SECOND", current/time.Second)).Scan(&expired); err != nil {
+		return time.Time{}, err
+	}
+	if expired == 0 {
+		return time.Time{}, nil
+	}
+	return effective, nil
+}
+
+// Records a TTL change in default.flows_ttl_history, which dashboards read the retention from, or a wait
+// for the previous change to take effect, with the same TTL and previous TTL.
+func recordTTL(connect *sql.DB, previous, ttl time.Duration, usage float64, reason string) error {
+	if _, err := connect.Exec(`CREATE TABLE IF NOT EXISTS default.flows_ttl_history (
+		timeChanged DateTime,
+		ttlSeconds UInt64,
+		previousTTLSeconds UInt64,
+		storageUsage Float64,
+		reason String
+	) ENGINE = MergeTree
+	ORDER BY timeChanged`); err != nil {
+		return err
+	}
+	tx, err := connect.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO default.flows_ttl_history (timeChanged, ttlSeconds, previousTTLSeconds, storageUsage, reason) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	if _, err := stmt.Exec(time.Now(), uint64(ttl/time.Second), uint64(previous/time.Second), usage, reason); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
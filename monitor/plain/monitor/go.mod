@@ -3,11 +3,14 @@ module clickhouse/monitor
 go 1.17
 
 require (
-	github.com/ClickHouse/clickhouse-go v1.5.1
+	clickhouse/common v0.0.0
 	k8s.io/klog/v2 v2.30.0
 )
 
 require (
+	github.com/ClickHouse/clickhouse-go v1.5.1 // indirect
 	github.com/cloudflare/golz4 v0.0.0-20150217214814-ef862a3cdc58 // indirect
 	github.com/go-logr/logr v1.2.0 // indirect
 )
+
+replace clickhouse/common => ../../../common
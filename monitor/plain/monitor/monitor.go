@@ -2,13 +2,19 @@ package main
 
 import (
 	"database/sql"
+	"flag"
 	"fmt"
 	"time"
 
-	"github.com/ClickHouse/clickhouse-go"
 	"k8s.io/klog/v2"
+
+	"clickhouse/common/connection"
+	"clickhouse/common/tlsconfig"
 )
 
+// The -tls flags of the connection to Clickhouse.
+var tlsFlags tlsconfig.Flags
+
 const (
 	// The limit of storage used by the clickhouse in byte. The default value is 1G.
 	limitedSpace = 1024 * 1024 * 1024
@@ -21,8 +27,11 @@ const (
 
 	// Connection to Clickhouse timeout if if fails for 5 minutes
 	connectionTimeout = 5 * time.Minute
-	// Retry connection to Clickhouse every 5 seconds if it fails
-	connectionWait = 5 * time.Second
+	// The first retry of the connection to Clickhouse is after 1 second, the wait doubles up to 30 seconds
+	connectionInitialWait = time.Second
+	connectionMaxWait     = 30 * time.Second
+	// Timeout of a connection attempt
+	connectionAttemptTimeout = 10 * time.Second
 )
 
 func monitorMemory(connect *sql.DB) {
@@ -70,34 +79,22 @@ func getDeleteRowNum(connect *sql.DB) uint64 {
 }
 
 func connectLoop() (*sql.DB, error) {
-	ticker := time.NewTicker(connectionWait)
-	defer ticker.Stop()
-
-	timeoutExceeded := time.After(connectionTimeout)
-	for {
-		select {
-		case <-timeoutExceeded:
-			return nil, fmt.Errorf("clickhouse connection failed after %s", connectionTimeout)
-
-		case <-ticker.C:
-			connect, err := sql.Open("clickhouse", "tcp://localhost:9000?debug=true&username=clickhouse_operator&password=clickhouse_operator_password")
-			if err != nil {
-				klog.Info("failed to connect to clickhouse: ", err)
-			}
-			if err := connect.Ping(); err != nil {
-				if exception, ok := err.(*clickhouse.Exception); ok {
-					fmt.Printf("[%d] %s \n%s\n", exception.Code, exception.Message, exception.StackTrace)
-				} else {
-					fmt.Println(err)
-				}
-			} else {
-				return connect, nil
-			}
-		}
+	port, tlsParams, err := connection.NativePortAndTLSParameters(&tlsFlags)
+	if err != nil {
+		return nil, err
 	}
+	dsn := fmt.Sprintf("tcp://localhost:%d?debug=true&username=clickhouse_operator&password=clickhouse_operator_password%s", port, tlsParams)
+	return connection.WithBackoff(dsn, connection.Backoff{
+		Initial:        connectionInitialWait,
+		Max:            connectionMaxWait,
+		AttemptTimeout: connectionAttemptTimeout,
+		Timeout:        connectionTimeout,
+	})
 }
 
 func main() {
+	tlsFlags.Register(flag.CommandLine, "connect over TLS, to the secure port 9440")
+	flag.Parse()
 	connect, err := connectLoop()
 	if err != nil {
 		klog.Fatal(err)
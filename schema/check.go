@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The clauses of SHOW CREATE TABLE compared with the expected definitions. Older
+// servers print the statement on one line, so a clause ends at the next clause.
+var (
+	engineClause  = regexp.MustCompile(`ENGINE = (\w+)`)
+	orderByClause = regexp.MustCompile(`(?s)ORDER BY (.+?)\s*(?:PRIMARY KEY|SAMPLE BY|TTL|SETTINGS|AS SELECT|$)`)
+	ttlClause     = regexp.MustCompile(`(?s)\sTTL (.+?)\s*(?:SETTINGS|AS SELECT|$)`)
+)
+
+// ttlIntervalValue is the interval of a TTL clause, which the monitor and the ttl
+// command change at run time.
+var ttlIntervalValue = regexp.MustCompile(`toInterval\w+\(\d+\)`)
+
+// deployedTable is the definition of a table read from the server.
+type deployedTable struct {
+	engine  string
+	orderBy string
+	ttl     string
+	columns []column
+}
+
+// readTable returns the definition of a table or materialized view, nil if it does
+// not exist. The engine of a materialized view is the engine of its inner table.
+func readTable(connect *sql.DB, database, name string) (*deployedTable, error) {
+	var count uint64
+	if err := connect.QueryRow("SELECT COUNT() FROM system.tables WHERE database = ? AND name = ?", database, name).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	var statement string
+	if err := connect.QueryRow(fmt.Sprintf("SHOW CREATE TABLE %s.%s", database, name)).Scan(&statement); err != nil {
+		return nil, err
+	}
+	t := &deployedTable{}
+	if match := engineClause.FindStringSubmatch(statement); match != nil {
+		t.engine = match[1]
+	}
+	if match := orderByClause.FindStringSubmatch(statement); match != nil {
+		t.orderBy = match[1]
+	}
+	if match := ttlClause.FindStringSubmatch(statement); match != nil {
+		t.ttl = match[1]
+	}
+	rows, err := connect.Query("SELECT name, type FROM system.columns WHERE database = ? AND table = ? ORDER BY position", database, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c column
+		if err := rows.Scan(&c.name, &c.typ); err != nil {
+			return nil, err
+		}
+		t.columns = append(t.columns, c)
+	}
+	return t, rows.Err()
+}
+
+// normalize drops the whitespace and quotes that differ between server versions.
+func normalize(expression string) string {
+	return strings.NewReplacer("`", "", " ", "", "\n", "", "\t", "").Replace(expression)
+}
+
+// normalizeTTL normalizes a TTL clause without its interval, so that the TTL only
+// differs from the expected one when it is missing or not on the same column.
+func normalizeTTL(ttl string) string {
+	return normalize(ttlIntervalValue.ReplaceAllString(ttl, "toInterval()"))
+}
+
+// compareTable returns the differences between the deployed and the expected definition.
+func compareTable(expected tableDefinition, deployed *deployedTable) []string {
+	if deployed == nil {
+		return []string{fmt.Sprintf("%s: table is missing", expected.name)}
+	}
+	var differences []string
+	if deployed.engine != expected.engine {
+		differences = append(differences, fmt.Sprintf("%s: engine is %s, expected %s", expected.name, deployed.engine, expected.engine))
+	}
+	if normalize(deployed.orderBy) != normalize(expected.orderBy) {
+		differences = append(differences, fmt.Sprintf("%s: ORDER BY is %q, expected %q", expected.name, deployed.orderBy, expected.orderBy))
+	}
+	if normalizeTTL(deployed.ttl) != normalizeTTL(expected.ttl) {
+		differences = append(differences, fmt.Sprintf("%s: TTL is %q, expected %q", expected.name, deployed.ttl, expected.ttl))
+	}
+	types := map[string]string{}
+	for _, c := range deployed.columns {
+		types[c.name] = c.typ
+	}
+	for _, c := range expected.columns {
+		typ, ok := types[c.name]
+		if !ok {
+			differences = append(differences, fmt.Sprintf("%s: column %s is missing", expected.name, c.name))
+		} else if typ != c.typ {
+			differences = append(differences, fmt.Sprintf("%s: column %s is %s, expected %s", expected.name, c.name, typ, c.typ))
+		}
+		delete(types, c.name)
+	}
+	for _, c := range deployed.columns {
+		if _, ok := types[c.name]; ok {
+			differences = append(differences, fmt.Sprintf("%s: unexpected column %s %s", expected.name, c.name, c.typ))
+		}
+	}
+	return differences
+}
+
+// check prints the differences between the deployed tables and the expected
+// definitions and returns an error if there are any.
+func check(connect *sql.DB, database string, cluster bool) error {
+	count := 0
+	for _, expected := range expectedTables(cluster) {
+		deployed, err := readTable(connect, database, expected.name)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", expected.name, err)
+		}
+		differences := compareTable(expected, deployed)
+		for _, difference := range differences {
+			fmt.Println(difference)
+		}
+		count += len(differences)
+	}
+	if count > 0 {
+		return fmt.Errorf("found %d differences from the expected schema", count)
+	}
+	fmt.Println("schema matches the expected definitions")
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCompareTable(t *testing.T) {
+	expected := tableDefinition{
+		name:    "flows",
+		engine:  "MergeTree",
+		orderBy: "(timeInserted, flowEndSeconds)",
+		ttl:     flowsTTL,
+		columns: []column{{"timeInserted", "DateTime"}, {"sourcePodName", "String"}},
+	}
+	for _, tc := range []struct {
+		name     string
+		deployed *deployedTable
+		expected []string
+	}{
+		{
+			name: "same definition",
+			deployed: &deployedTable{engine: "MergeTree", orderBy: "(timeInserted, flowEndSeconds)", ttl: "timeInserted + toIntervalHour(1)",
+				columns: []column{{"timeInserted", "DateTime"}, {"sourcePodName", "String"}}},
+		},
+		{
+			name: "TTL changed at run time",
+			deployed: &deployedTable{engine: "MergeTree", orderBy: "(timeInserted, flowEndSeconds)", ttl: "timeInserted + toIntervalSecond(600)",
+				columns: []column{{"timeInserted", "DateTime"}, {"sourcePodName", "String"}}},
+		},
+		{
+			name:     "missing table",
+			expected: []string{"flows: table is missing"},
+		},
+		{
+			name: "different definition",
+			deployed: &deployedTable{engine: "SummingMergeTree", orderBy: "timeInserted", ttl: "flowEndSeconds + toIntervalHour(1)",
+				columns: []column{{"timeInserted", "DateTime"}, {"sourcePodName", "UInt8"}, {"trusted", "UInt8"}}},
+			expected: []string{
+				"flows: engine is SummingMergeTree, expected MergeTree",
+				`flows: ORDER BY is "timeInserted", expected "(timeInserted, flowEndSeconds)"`,
+				`flows: TTL is "flowEndSeconds + toIntervalHour(1)", expected "timeInserted + toIntervalHour(1)"`,
+				"flows: column sourcePodName is UInt8, expected String",
+				"flows: unexpected column trusted UInt8",
+			},
+		},
+		{
+			name: "missing TTL and column",
+			deployed: &deployedTable{engine: "MergeTree", orderBy: "(timeInserted, flowEndSeconds)",
+				columns: []column{{"timeInserted", "DateTime"}}},
+			expected: []string{
+				`flows: TTL is "", expected "timeInserted + toIntervalHour(1)"`,
+				"flows: column sourcePodName is missing",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if differences := compareTable(expected, tc.deployed); !reflect.DeepEqual(differences, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, differences)
+			}
+		})
+	}
+}
+
+var (
+	createTable = regexp.MustCompile(`^CREATE TABLE IF NOT EXISTS (\w+)[^(]*\($`)
+	createAs    = regexp.MustCompile(`^CREATE TABLE IF NOT EXISTS (\w+) .*AS (\w+)$`)
+	columnLine  = regexp.MustCompile(`^\s*(\w+) (\w+)`)
+	addColumn   = regexp.MustCompile(`^ALTER TABLE (\w+) .*ADD COLUMN IF NOT EXISTS (\w+) (\w+)`)
+)
+
+// migratedColumns returns the columns of the tables created with a column list, or AS
+// such a table, by the up migrations of a mode, in order.
+func migratedColumns(t *testing.T, mode string) map[string][]column {
+	migrations, err := loadMigrations(mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := map[string][]column{}
+	for _, m := range migrations {
+		for _, statement := range splitStatements(m.up) {
+			lines := strings.Split(statement, "\n")
+			if match := createTable.FindStringSubmatch(lines[0]); match != nil {
+				for _, line := range lines[1:] {
+					if strings.HasPrefix(line, ")") {
+						break
+					}
+					if c := columnLine.FindStringSubmatch(line); c != nil {
+						tables[match[1]] = append(tables[match[1]], column{c[1], c[2]})
+					}
+				}
+			} else if match := createAs.FindStringSubmatch(lines[0]); match != nil {
+				if columns, ok := tables[match[2]]; ok {
+					tables[match[1]] = append([]column{}, columns...)
+				}
+			} else if match := addColumn.FindStringSubmatch(statement); match != nil {
+				tables[match[1]] = append(tables[match[1]], column{match[2], match[3]})
+			}
+		}
+	}
+	return tables
+}
+
+// TestExpectedTablesMatchMigrations checks that the expected definitions follow the
+// migrations for the tables created with a column list, the columns of the views being
+// those of their SELECT.
+func TestExpectedTablesMatchMigrations(t *testing.T) {
+	for _, mode := range []string{"single", "cluster"} {
+		t.Run(mode, func(t *testing.T) {
+			migrated := migratedColumns(t, mode)
+			if len(migrated) == 0 {
+				t.Fatal("no table created with a column list")
+			}
+			for _, expected := range expectedTables(mode == "cluster") {
+				columns, ok := migrated[expected.name]
+				if !ok {
+					continue
+				}
+				delete(migrated, expected.name)
+				if !reflect.DeepEqual(columns, expected.columns) {
+					t.Errorf("%s: the migrations create the columns %v, expected %v", expected.name, columns, expected.columns)
+				}
+			}
+			for name := range migrated {
+				t.Errorf("table %s of the migrations has no expected definition", name)
+			}
+		})
+	}
+}
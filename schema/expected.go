@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strings"
+)
+
+// column is a column of an expected table.
+type column struct {
+	name string
+	typ  string
+}
+
+// tableDefinition is the expected definition of a table or materialized view, as
+// created by the migrations. orderBy and ttl are written like SHOW CREATE TABLE
+// prints them. The interval of ttl is the one of the migrations, the deployed one is
+// not compared as the monitor and the ttl command change it.
+type tableDefinition struct {
+	name    string
+	engine  string
+	orderBy string
+	ttl     string
+	columns []column
+}
+
+var flowsColumns = []column{
+	{"timeInserted", "DateTime"},
+	{"flowStartSeconds", "DateTime"},
+	{"flowEndSeconds", "DateTime"},
+	{"flowEndSecondsFromSourceNode", "DateTime"},
+	{"flowEndSecondsFromDestinationNode", "DateTime"},
+	{"flowEndReason", "UInt8"},
+	{"sourceIP", "String"},
+	{"destinationIP", "String"},
+	{"sourceTransportPort", "UInt16"},
+	{"destinationTransportPort", "UInt16"},
+	{"protocolIdentifier", "UInt8"},
+	{"packetTotalCount", "UInt64"},
+	{"octetTotalCount", "UInt64"},
+	{"packetDeltaCount", "UInt64"},
+	{"octetDeltaCount", "UInt64"},
+	{"reversePacketTotalCount", "UInt64"},
+	{"reverseOctetTotalCount", "UInt64"},
+	{"reversePacketDeltaCount", "UInt64"},
+	{"reverseOctetDeltaCount", "UInt64"},
+	{"sourcePodName", "String"},
+	{"sourcePodNamespace", "String"},
+	{"sourceNodeName", "String"},
+	{"destinationPodName", "String"},
+	{"destinationPodNamespace", "String"},
+	{"destinationNodeName", "String"},
+	{"destinationClusterIP", "String"},
+	{"destinationServicePort", "UInt16"},
+	{"destinationServicePortName", "String"},
+	{"ingressNetworkPolicyName", "String"},
+	{"ingressNetworkPolicyNamespace", "String"},
+	{"ingressNetworkPolicyRuleName", "String"},
+	{"ingressNetworkPolicyRuleAction", "UInt8"},
+	{"ingressNetworkPolicyType", "UInt8"},
+	{"egressNetworkPolicyName", "String"},
+	{"egressNetworkPolicyNamespace", "String"},
+	{"egressNetworkPolicyRuleName", "String"},
+	{"egressNetworkPolicyRuleAction", "UInt8"},
+	{"egressNetworkPolicyType", "UInt8"},
+	{"tcpState", "String"},
+	{"flowType", "UInt8"},
+	{"sourcePodLabels", "String"},
+	{"destinationPodLabels", "String"},
+	{"throughput", "UInt64"},
+	{"reverseThroughput", "UInt64"},
+	{"throughputFromSourceNode", "UInt64"},
+	{"throughputFromDestinationNode", "UInt64"},
+	{"reverseThroughputFromSourceNode", "UInt64"},
+	{"reverseThroughputFromDestinationNode", "UInt64"},
+	{"trusted", "UInt8"},
+}
+
+const flowsTTL = "timeInserted + toIntervalHour(1)"
+
+// view returns the definition of a flows view grouping by keys and summing sums.
+func view(name string, keys, sums []string) tableDefinition {
+	types := map[string]string{}
+	for _, c := range flowsColumns {
+		types[c.name] = c.typ
+	}
+	t := tableDefinition{
+		name:    name,
+		engine:  "SummingMergeTree",
+		orderBy: "(" + strings.Join(keys, ", ") + ")",
+		ttl:     flowsTTL,
+	}
+	for _, key := range keys {
+		t.columns = append(t.columns, column{key, types[key]})
+	}
+	for _, sum := range sums {
+		t.columns = append(t.columns, column{sum, types[sum]})
+	}
+	return t
+}
+
+var viewTimes = []string{"timeInserted", "flowEndSeconds", "flowEndSecondsFromSourceNode", "flowEndSecondsFromDestinationNode"}
+
+func keys(names ...string) []string {
+	return append(append([]string{}, viewTimes...), names...)
+}
+
+// singleTables are the tables created by the single node migrations.
+var singleTables = []tableDefinition{
+	{
+		name:    "flows",
+		engine:  "MergeTree",
+		orderBy: "(timeInserted, flowEndSeconds)",
+		ttl:     flowsTTL,
+		columns: flowsColumns,
+	},
+	view("flows_pod_view",
+		keys("sourcePodName", "destinationPodName", "destinationIP", "destinationServicePortName", "flowType", "sourcePodNamespace", "destinationPodNamespace"),
+		[]string{"octetDeltaCount", "reverseOctetDeltaCount", "throughput", "reverseThroughput", "throughputFromSourceNode", "throughputFromDestinationNode"}),
+	view("flows_node_view",
+		keys("sourceNodeName", "destinationNodeName", "sourcePodNamespace", "destinationPodNamespace"),
+		[]string{"octetDeltaCount", "reverseOctetDeltaCount", "throughput", "reverseThroughput", "throughputFromSourceNode",
+			"reverseThroughputFromSourceNode", "throughputFromDestinationNode", "reverseThroughputFromDestinationNode"}),
+	view("flows_policy_view",
+		keys("egressNetworkPolicyName", "egressNetworkPolicyRuleAction", "ingressNetworkPolicyName", "ingressNetworkPolicyRuleAction", "sourcePodNamespace", "destinationPodNamespace"),
+		[]string{"octetDeltaCount", "reverseOctetDeltaCount", "throughput", "reverseThroughput", "throughputFromSourceNode",
+			"reverseThroughputFromSourceNode", "throughputFromDestinationNode", "reverseThroughputFromDestinationNode"}),
+	{
+		name:    "recommendations",
+		engine:  "MergeTree",
+		orderBy: "timeCreated",
+		columns: []column{{"id", "String"}, {"type", "String"}, {"timeCreated", "DateTime"}, {"yamls", "String"}},
+	},
+}
+
+// clusterTables returns the tables created by the cluster migrations: replicated
+// <table>_local tables of the single node tables and Distributed tables over them.
+func clusterTables() []tableDefinition {
+	var tables []tableDefinition
+	for _, t := range singleTables {
+		local := t
+		local.name = t.name + "_local"
+		local.engine = "Replicated" + t.engine
+		distributed := tableDefinition{name: t.name, engine: "Distributed", columns: t.columns}
+		tables = append(tables, local, distributed)
+	}
+	return tables
+}
+
+// expectedTables returns the expected tables of the single node or cluster schema.
+func expectedTables(cluster bool) []tableDefinition {
+	if cluster {
+		return clusterTables()
+	}
+	return singleTables
+}
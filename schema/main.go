@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+
+	_ "github.com/ClickHouse/clickhouse-go/v2"
+	"k8s.io/klog/v2"
+)
+
+var host, username, password, database string
+var port int
+var cluster bool
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] <command> [command flags]
+
+Commands:
+  up [-to version]      apply the pending migrations, up to version if set
+  down [-to version]    revert the migrations above version, the last one by default
+  status                list the migrations and detect drift
+  check                 compare the deployed tables with the expected definitions
+  ttl [show]            show the TTL of flows and its views
+  ttl set [-ttl d] [-merge-timeout d] [-materialize] [-dry-run]
+                        change the TTL of flows and its views alike
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func connect() *sql.DB {
+	dsn := fmt.Sprintf("tcp://%s:%s@%s/%s", url.QueryEscape(username), url.QueryEscape(password),
+		net.JoinHostPort(host, strconv.Itoa(port)), database)
+	connect, err := sql.Open("clickhouse", dsn)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	if err := connect.Ping(); err != nil {
+		klog.Fatalf("failed to connect to ClickHouse at %s: %v", host, err)
+	}
+	return connect
+}
+
+func main() {
+	// example: apply the migrations to a single ClickHouse server
+	// go run . -h 127.0.0.1 up
+	// or to a cluster, using the {cluster} macro of the servers
+	// go run . -h 127.0.0.1 -cluster up
+	// revert the last migration
+	// go run . -h 127.0.0.1 down
+	// compare the deployed schema with the expected one, exiting with 1 on differences
+	// go run . -h 127.0.0.1 check
+	// keep flows for 2 hours, merging expired rows at most every 10 minutes
+	// go run . -h 127.0.0.1 ttl set -ttl 2h -merge-timeout 10m
+	flag.StringVar(&host, "h", "127.0.0.1", "ClickHouse host")
+	flag.IntVar(&port, "port", 9000, "ClickHouse native protocol port")
+	flag.StringVar(&username, "username", "clickhouse_operator", "ClickHouse username")
+	flag.StringVar(&password, "password", "clickhouse_operator_password", "ClickHouse password")
+	flag.StringVar(&database, "database", "default", "database of the flows tables")
+	flag.BoolVar(&cluster, "cluster", false, "apply the cluster migrations ON CLUSTER '{cluster}' instead of the single node ones")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	var err error
+	switch command {
+	case "up", "down":
+		fs := flag.NewFlagSet(command, flag.ExitOnError)
+		target := fs.Int("to", 0, "target version")
+		if command == "down" {
+			*target = -1
+		}
+		fs.Parse(args)
+		m, merr := newMigrator(connect(), cluster)
+		if merr != nil {
+			klog.Fatal(merr)
+		}
+		if command == "up" {
+			err = m.up(*target)
+		} else {
+			err = m.down(*target)
+		}
+	case "status":
+		m, merr := newMigrator(connect(), cluster)
+		if merr != nil {
+			klog.Fatal(merr)
+		}
+		err = m.status()
+	case "check":
+		err = check(connect(), database, cluster)
+	case "ttl":
+		err = runTTL(connect(), args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		klog.Error(err)
+		os.Exit(1)
+	}
+}
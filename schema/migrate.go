@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migration is a versioned schema change, read from <version>_<name>.up.sql and
+// <version>_<name>.down.sql.
+type migration struct {
+	version  int
+	name     string
+	up       string
+	down     string
+	checksum string
+}
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// loadMigrations returns the migrations of a mode, single or cluster, sorted by version.
+func loadMigrations(mode string) ([]migration, error) {
+	dir := path.Join("migrations", mode)
+	if _, err := fs.Stat(migrationFiles, dir); err != nil {
+		return nil, fmt.Errorf("unknown mode %q", mode)
+	}
+	return readMigrations(migrationFiles, dir)
+}
+
+// readMigrations returns the migrations of a directory sorted by version. Versions must
+// start at 1 and have no gaps.
+func readMigrations(files fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		} else if m.name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.name, match[2])
+		}
+		content, err := fs.ReadFile(files, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.up = string(content)
+			sum := sha256.Sum256(content)
+			m.checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.down = string(content)
+		}
+	}
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs an up and a down file", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// splitStatements splits a migration file into its statements, which end with a
+// semicolon at the end of a line. Comment lines are dropped.
+func splitStatements(content string) []string {
+	var statements []string
+	var current []string
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		current = append(current, line)
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			statement := strings.TrimSpace(strings.Join(current, "\n"))
+			statements = append(statements, strings.TrimSuffix(statement, ";"))
+			current = nil
+		}
+	}
+	if statement := strings.TrimSpace(strings.Join(current, "\n")); statement != "" {
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+// appliedMigration is the state of a migration recorded in schema_migrations.
+type appliedMigration struct {
+	version  int
+	name     string
+	checksum string
+}
+
+// migrator applies migrations and records them in the schema_migrations table, an
+// append-only log of up and down runs: a version is applied if its last run is up.
+type migrator struct {
+	connect    *sql.DB
+	cluster    bool
+	migrations []migration
+}
+
+func newMigrator(connect *sql.DB, cluster bool) (*migrator, error) {
+	mode := "single"
+	if cluster {
+		mode = "cluster"
+	}
+	migrations, err := loadMigrations(mode)
+	if err != nil {
+		return nil, err
+	}
+	return &migrator{connect: connect, cluster: cluster, migrations: migrations}, nil
+}
+
+func (m *migrator) createTable() error {
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version UInt32,
+		name String,
+		checksum String,
+		direction String,
+		sequence UInt64,
+		appliedAt DateTime DEFAULT now()
+	) ENGINE = MergeTree
+	ORDER BY (version, sequence)`
+	if m.cluster {
+		query = strings.Replace(query, "schema_migrations (", "schema_migrations ON CLUSTER '{cluster}' (", 1)
+		query = strings.Replace(query, "ENGINE = MergeTree",
+			"ENGINE = ReplicatedMergeTree('/clickhouse/{installation}/{cluster}/tables/{shard}/{database}/{table}', '{replica}')", 1)
+	}
+	_, err := m.connect.Exec(query)
+	return err
+}
+
+// applied returns the applied migrations sorted by version.
+func (m *migrator) applied() ([]appliedMigration, error) {
+	rows, err := m.connect.Query(`SELECT version, argMax(name, sequence), argMax(checksum, sequence)
+		FROM schema_migrations
+		GROUP BY version
+		HAVING argMax(direction, sequence) = 'up'
+		ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var applied []appliedMigration
+	for rows.Next() {
+		var a appliedMigration
+		var version uint32
+		if err := rows.Scan(&version, &a.name, &a.checksum); err != nil {
+			return nil, err
+		}
+		a.version = int(version)
+		applied = append(applied, a)
+	}
+	return applied, rows.Err()
+}
+
+// drift returns the differences between the applied migrations and the migration files:
+// files changed after they were applied, unknown versions and gaps.
+func (m *migrator) drift(applied []appliedMigration) []string {
+	var problems []string
+	for i, a := range applied {
+		if a.version > len(m.migrations) {
+			problems = append(problems, fmt.Sprintf("migration %d_%s is applied but has no file", a.version, a.name))
+			continue
+		}
+		if a.version != i+1 {
+			problems = append(problems, fmt.Sprintf("migration %d_%s is applied but migration %d is not", a.version, a.name, i+1))
+		}
+		if file := m.migrations[a.version-1]; file.checksum != a.checksum {
+			problems = append(problems, fmt.Sprintf("migration %d_%s changed after it was applied: checksum %s, applied %s",
+				a.version, file.name, file.checksum, a.checksum))
+		}
+	}
+	return problems
+}
+
+// current returns the applied version after checking for drift.
+func (m *migrator) current() (int, error) {
+	if err := m.createTable(); err != nil {
+		return 0, fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+	if problems := m.drift(applied); len(problems) > 0 {
+		return 0, fmt.Errorf("schema drift detected:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return len(applied), nil
+}
+
+// up applies the migrations up to version target, all of them if target is 0.
+func (m *migrator) up(target int) error {
+	if target == 0 || target > len(m.migrations) {
+		target = len(m.migrations)
+	}
+	version, err := m.current()
+	if err != nil {
+		return err
+	}
+	for ; version < target; version++ {
+		if err := m.run(m.migrations[version], "up"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// down reverts the migrations above version target, the last one if target is negative.
+func (m *migrator) down(target int) error {
+	version, err := m.current()
+	if err != nil {
+		return err
+	}
+	if target < 0 {
+		target = version - 1
+	}
+	for ; version > target; version-- {
+		if err := m.run(m.migrations[version-1], "down"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run executes the statements of a migration in direction, up or down, and records it.
+// A failed migration is not recorded, its statements are idempotent and it can be rerun.
+func (m *migrator) run(mig migration, direction string) error {
+	content := mig.up
+	if direction == "down" {
+		content = mig.down
+	}
+	klog.Infof("Migrating %s: %d_%s", direction, mig.version, mig.name)
+	for i, statement := range splitStatements(content) {
+		if _, err := m.connect.Exec(statement); err != nil {
+			return fmt.Errorf("migration %d_%s %s failed at statement %d: %v", mig.version, mig.name, direction, i+1, err)
+		}
+	}
+	_, err := m.connect.Exec("INSERT INTO schema_migrations (version, name, checksum, direction, sequence) VALUES (?, ?, ?, ?, ?)",
+		uint32(mig.version), mig.name, mig.checksum, direction, uint64(time.Now().UnixNano()))
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d_%s: %v", mig.version, mig.name, err)
+	}
+	return nil
+}
+
+// status prints every migration and whether it is applied.
+func (m *migrator) status() error {
+	if err := m.createTable(); err != nil {
+		return err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	isApplied := map[int]bool{}
+	for _, a := range applied {
+		isApplied[a.version] = true
+	}
+	for _, mig := range m.migrations {
+		state := "pending"
+		if isApplied[mig.version] {
+			state = "applied"
+		}
+		fmt.Printf("%04d_%s\t%s\n", mig.version, mig.name, state)
+	}
+	problems := m.drift(applied)
+	for _, problem := range problems {
+		fmt.Printf("drift: %s\n", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("schema drift detected")
+	}
+	return nil
+}
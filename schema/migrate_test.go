@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSplitStatements(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name:     "one statement",
+			content:  "ALTER TABLE flows DROP COLUMN IF EXISTS trusted;\n",
+			expected: []string{"ALTER TABLE flows DROP COLUMN IF EXISTS trusted"},
+		},
+		{
+			name:     "statements on several lines",
+			content:  "CREATE TABLE t (\n    a UInt8\n) ENGINE = MergeTree\nORDER BY a;\n\nDROP TABLE u;\n",
+			expected: []string{"CREATE TABLE t (\n    a UInt8\n) ENGINE = MergeTree\nORDER BY a", "DROP TABLE u"},
+		},
+		{
+			name:     "comment lines",
+			content:  "-- adds a column;\nALTER TABLE t ADD COLUMN a UInt8;\n  -- indented comment\nALTER TABLE u ADD COLUMN a UInt8;\n",
+			expected: []string{"ALTER TABLE t ADD COLUMN a UInt8", "ALTER TABLE u ADD COLUMN a UInt8"},
+		},
+		{
+			name:     "semicolon inside a line",
+			content:  "SELECT ';' AS a, 1;\n",
+			expected: []string{"SELECT ';' AS a, 1"},
+		},
+		{
+			name:     "last statement without semicolon",
+			content:  "DROP TABLE t;\nDROP TABLE u\n",
+			expected: []string{"DROP TABLE t", "DROP TABLE u"},
+		},
+		{
+			name:    "only comments",
+			content: "-- nothing to do\n\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if statements := splitStatements(tc.content); !reflect.DeepEqual(statements, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, statements)
+			}
+		})
+	}
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLoadMigrations(t *testing.T) {
+	for _, mode := range []string{"single", "cluster"} {
+		t.Run(mode, func(t *testing.T) {
+			migrations, err := loadMigrations(mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(migrations) < 2 || migrations[0].name != "init" || migrations[1].name != "add_trusted" {
+				t.Fatalf("expected init and add_trusted first, got %+v", migrations)
+			}
+			for i, m := range migrations {
+				if m.version != i+1 {
+					t.Errorf("expected version %d at %d, got %d", i+1, i, m.version)
+				}
+				if m.checksum != checksum(m.up) {
+					t.Errorf("migration %d_%s: the checksum is not the one of its up file", m.version, m.name)
+				}
+				if len(splitStatements(m.up)) == 0 || len(splitStatements(m.down)) == 0 {
+					t.Errorf("migration %d_%s has no statements", m.version, m.name)
+				}
+			}
+		})
+	}
+	if _, err := loadMigrations("sharded"); err == nil || !strings.Contains(err.Error(), `unknown mode "sharded"`) {
+		t.Errorf("expected an error for an unknown mode, got %v", err)
+	}
+}
+
+func TestReadMigrations(t *testing.T) {
+	files := func(names ...string) fstest.MapFS {
+		fsys := fstest.MapFS{}
+		for _, name := range names {
+			fsys["m/"+name] = &fstest.MapFile{Data: []byte("-- " + name + "\nSELECT 1;\n")}
+		}
+		return fsys
+	}
+
+	// versions are ordered as numbers, not as file names
+	migrations, err := readMigrations(files("10_j.up.sql", "10_j.down.sql", "2_b.up.sql", "2_b.down.sql", "1_a.up.sql", "1_a.down.sql",
+		"3_c.up.sql", "3_c.down.sql", "4_d.up.sql", "4_d.down.sql", "5_e.up.sql", "5_e.down.sql", "6_f.up.sql", "6_f.down.sql",
+		"7_g.up.sql", "7_g.down.sql", "8_h.up.sql", "8_h.down.sql", "9_i.up.sql", "9_i.down.sql"), "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range migrations {
+		names = append(names, m.name)
+	}
+	if strings.Join(names, "") != "abcdefghij" {
+		t.Errorf("expected the migrations in version order, got %v", names)
+	}
+	if migrations[1].checksum != checksum("-- 2_b.up.sql\nSELECT 1;\n") || migrations[0].checksum == migrations[1].checksum {
+		t.Errorf("expected the checksum of the up file, got %s", migrations[1].checksum)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		files    fstest.MapFS
+		expected string
+	}{
+		{
+			name:     "missing down file",
+			files:    files("1_a.up.sql", "1_a.down.sql", "2_b.up.sql"),
+			expected: "migration 2_b needs an up and a down file",
+		},
+		{
+			name:     "gap",
+			files:    files("1_a.up.sql", "1_a.down.sql", "3_c.up.sql", "3_c.down.sql"),
+			expected: "migration 2 is missing",
+		},
+		{
+			name:     "two names",
+			files:    files("1_a.up.sql", "1_b.down.sql"),
+			expected: "migration 1 has two names",
+		},
+		{
+			name:     "unexpected file",
+			files:    files("1_a.up.sql", "1_a.down.sql", "README.md"),
+			expected: "unexpected migration file README.md",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := readMigrations(tc.files, "m"); err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("expected an error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestDrift(t *testing.T) {
+	m := &migrator{migrations: []migration{
+		{version: 1, name: "init", checksum: "c1"},
+		{version: 2, name: "add_trusted", checksum: "c2"},
+		{version: 3, name: "add_index", checksum: "c3"},
+	}}
+	for _, tc := range []struct {
+		name     string
+		applied  []appliedMigration
+		expected []string
+	}{
+		{
+			name: "nothing applied",
+		},
+		{
+			name:    "applied in order",
+			applied: []appliedMigration{{1, "init", "c1"}, {2, "add_trusted", "c2"}},
+		},
+		{
+			name:     "changed after it was applied",
+			applied:  []appliedMigration{{1, "init", "c1"}, {2, "add_trusted", "old"}},
+			expected: []string{"migration 2_add_trusted changed after it was applied: checksum c2, applied old"},
+		},
+		{
+			name:     "gap",
+			applied:  []appliedMigration{{1, "init", "c1"}, {3, "add_index", "c3"}},
+			expected: []string{"migration 3_add_index is applied but migration 2 is not"},
+		},
+		{
+			name:     "applied without file",
+			applied:  []appliedMigration{{1, "init", "c1"}, {2, "add_trusted", "c2"}, {3, "add_index", "c3"}, {4, "drop_views", "c4"}},
+			expected: []string{"migration 4_drop_views is applied but has no file"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if problems := m.drift(tc.applied); !reflect.DeepEqual(problems, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS recommendations ON CLUSTER '{cluster}';
+DROP TABLE IF EXISTS flows_policy_view ON CLUSTER '{cluster}';
+DROP TABLE IF EXISTS flows_node_view ON CLUSTER '{cluster}';
+DROP TABLE IF EXISTS flows_pod_view ON CLUSTER '{cluster}';
+DROP TABLE IF EXISTS flows ON CLUSTER '{cluster}';
+DROP TABLE IF EXISTS recommendations_local ON CLUSTER '{cluster}';
+DROP TABLE IF EXISTS flows_policy_view_local ON CLUSTER '{cluster}';
+DROP TABLE IF EXISTS flows_node_view_local ON CLUSTER '{cluster}';
+DROP TABLE IF EXISTS flows_pod_view_local ON CLUSTER '{cluster}';
+DROP TABLE IF EXISTS flows_local ON CLUSTER '{cluster}';
@@ -0,0 +1,215 @@
+CREATE TABLE IF NOT EXISTS flows_local ON CLUSTER '{cluster}' (
+    timeInserted DateTime DEFAULT now(),
+    flowStartSeconds DateTime,
+    flowEndSeconds DateTime,
+    flowEndSecondsFromSourceNode DateTime,
+    flowEndSecondsFromDestinationNode DateTime,
+    flowEndReason UInt8,
+    sourceIP String,
+    destinationIP String,
+    sourceTransportPort UInt16,
+    destinationTransportPort UInt16,
+    protocolIdentifier UInt8,
+    packetTotalCount UInt64,
+    octetTotalCount UInt64,
+    packetDeltaCount UInt64,
+    octetDeltaCount UInt64,
+    reversePacketTotalCount UInt64,
+    reverseOctetTotalCount UInt64,
+    reversePacketDeltaCount UInt64,
+    reverseOctetDeltaCount UInt64,
+    sourcePodName String,
+    sourcePodNamespace String,
+    sourceNodeName String,
+    destinationPodName String,
+    destinationPodNamespace String,
+    destinationNodeName String,
+    destinationClusterIP String,
+    destinationServicePort UInt16,
+    destinationServicePortName String,
+    ingressNetworkPolicyName String,
+    ingressNetworkPolicyNamespace String,
+    ingressNetworkPolicyRuleName String,
+    ingressNetworkPolicyRuleAction UInt8,
+    ingressNetworkPolicyType UInt8,
+    egressNetworkPolicyName String,
+    egressNetworkPolicyNamespace String,
+    egressNetworkPolicyRuleName String,
+    egressNetworkPolicyRuleAction UInt8,
+    egressNetworkPolicyType UInt8,
+    tcpState String,
+    flowType UInt8,
+    sourcePodLabels String,
+    destinationPodLabels String,
+    throughput UInt64,
+    reverseThroughput UInt64,
+    throughputFromSourceNode UInt64,
+    throughputFromDestinationNode UInt64,
+    reverseThroughputFromSourceNode UInt64,
+    reverseThroughputFromDestinationNode UInt64
+) engine=ReplicatedMergeTree('/clickhouse/{installation}/{cluster}/tables/{shard}/{database}/{table}', '{replica}')
+ORDER BY (timeInserted, flowEndSeconds)
+TTL timeInserted + INTERVAL 1 HOUR
+SETTINGS merge_with_ttl_timeout = 3600;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS flows_pod_view_local ON CLUSTER '{cluster}'
+ENGINE = ReplicatedSummingMergeTree('/clickhouse/{installation}/{cluster}/tables/{shard}/{database}/{table}', '{replica}')
+ORDER BY (
+    timeInserted,
+    flowEndSeconds,
+    flowEndSecondsFromSourceNode,
+    flowEndSecondsFromDestinationNode,
+    sourcePodName,
+    destinationPodName,
+    destinationIP,
+    destinationServicePortName,
+    flowType,
+    sourcePodNamespace,
+    destinationPodNamespace)
+TTL timeInserted + INTERVAL 1 HOUR
+SETTINGS merge_with_ttl_timeout = 3600
+POPULATE
+AS SELECT
+    timeInserted,
+    flowEndSeconds,
+    flowEndSecondsFromSourceNode,
+    flowEndSecondsFromDestinationNode,
+    sourcePodName,
+    destinationPodName,
+    destinationIP,
+    destinationServicePortName,
+    flowType,
+    sourcePodNamespace,
+    destinationPodNamespace,
+    sum(octetDeltaCount) AS octetDeltaCount,
+    sum(reverseOctetDeltaCount) AS reverseOctetDeltaCount,
+    sum(throughput) AS throughput,
+    sum(reverseThroughput) AS reverseThroughput,
+    sum(throughputFromSourceNode) AS throughputFromSourceNode,
+    sum(throughputFromDestinationNode) AS throughputFromDestinationNode
+FROM flows_local
+GROUP BY
+    timeInserted,
+    flowEndSeconds,
+    flowEndSecondsFromSourceNode,
+    flowEndSecondsFromDestinationNode,
+    sourcePodName,
+    destinationPodName,
+    destinationIP,
+    destinationServicePortName,
+    flowType,
+    sourcePodNamespace,
+    destinationPodNamespace;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS flows_node_view_local ON CLUSTER '{cluster}'
+ENGINE = ReplicatedSummingMergeTree('/clickhouse/{installation}/{cluster}/tables/{shard}/{database}/{table}', '{replica}')
+ORDER BY (
+    timeInserted,
+    flowEndSeconds,
+    flowEndSecondsFromSourceNode,
+    flowEndSecondsFromDestinationNode,
+    sourceNodeName,
+    destinationNodeName,
+    sourcePodNamespace,
+    destinationPodNamespace)
+TTL timeInserted + INTERVAL 1 HOUR
+SETTINGS merge_with_ttl_timeout = 3600
+POPULATE
+AS SELECT
+    timeInserted,
+    flowEndSeconds,
+    flowEndSecondsFromSourceNode,
+    flowEndSecondsFromDestinationNode,
+    sourceNodeName,
+    destinationNodeName,
+    sourcePodNamespace,
+    destinationPodNamespace,
+    sum(octetDeltaCount) AS octetDeltaCount,
+    sum(reverseOctetDeltaCount) AS reverseOctetDeltaCount,
+    sum(throughput) AS throughput,
+    sum(reverseThroughput) AS reverseThroughput,
+    sum(throughputFromSourceNode) AS throughputFromSourceNode,
+    sum(reverseThroughputFromSourceNode) AS reverseThroughputFromSourceNode,
+    sum(throughputFromDestinationNode) AS throughputFromDestinationNode,
+    sum(reverseThroughputFromDestinationNode) AS reverseThroughputFromDestinationNode
+FROM flows_local
+GROUP BY
+    timeInserted,
+    flowEndSeconds,
+    flowEndSecondsFromSourceNode,
+    flowEndSecondsFromDestinationNode,
+    sourceNodeName,
+    destinationNodeName,
+    sourcePodNamespace,
+    destinationPodNamespace;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS flows_policy_view_local ON CLUSTER '{cluster}'
+ENGINE = ReplicatedSummingMergeTree('/clickhouse/{installation}/{cluster}/tables/{shard}/{database}/{table}', '{replica}')
+ORDER BY (
+    timeInserted,
+    flowEndSeconds,
+    flowEndSecondsFromSourceNode,
+    flowEndSecondsFromDestinationNode,
+    egressNetworkPolicyName,
+    egressNetworkPolicyRuleAction,
+    ingressNetworkPolicyName,
+    ingressNetworkPolicyRuleAction,
+    sourcePodNamespace,
+    destinationPodNamespace)
+TTL timeInserted + INTERVAL 1 HOUR
+SETTINGS merge_with_ttl_timeout = 3600
+POPULATE
+AS SELECT
+    timeInserted,
+    flowEndSeconds,
+    flowEndSecondsFromSourceNode,
+    flowEndSecondsFromDestinationNode,
+    egressNetworkPolicyName,
+    egressNetworkPolicyRuleAction,
+    ingressNetworkPolicyName,
+    ingressNetworkPolicyRuleAction,
+    sourcePodNamespace,
+    destinationPodNamespace,
+    sum(octetDeltaCount) AS octetDeltaCount,
+    sum(reverseOctetDeltaCount) AS reverseOctetDeltaCount,
+    sum(throughput) AS throughput,
+    sum(reverseThroughput) AS reverseThroughput,
+    sum(throughputFromSourceNode) AS throughputFromSourceNode,
+    sum(reverseThroughputFromSourceNode) AS reverseThroughputFromSourceNode,
+    sum(throughputFromDestinationNode) AS throughputFromDestinationNode,
+    sum(reverseThroughputFromDestinationNode) AS reverseThroughputFromDestinationNode
+FROM flows_local
+GROUP BY
+    timeInserted,
+    flowEndSeconds,
+    flowEndSecondsFromSourceNode,
+    flowEndSecondsFromDestinationNode,
+    egressNetworkPolicyName,
+    egressNetworkPolicyRuleAction,
+    ingressNetworkPolicyName,
+    ingressNetworkPolicyRuleAction,
+    sourcePodNamespace,
+    destinationPodNamespace;
+
+CREATE TABLE IF NOT EXISTS recommendations_local ON CLUSTER '{cluster}' (
+    id String,
+    type String,
+    timeCreated DateTime,
+    yamls String
+) engine=ReplicatedMergeTree('/clickhouse/{installation}/{cluster}/tables/{shard}/{database}/{table}', '{replica}')
+ORDER BY (timeCreated);
+
+CREATE TABLE IF NOT EXISTS flows ON CLUSTER '{cluster}' AS flows_local
+engine=Distributed('{cluster}', default, flows_local, rand());
+
+CREATE TABLE IF NOT EXISTS flows_pod_view ON CLUSTER '{cluster}' AS flows_pod_view_local
+engine=Distributed('{cluster}', default, flows_pod_view_local, rand());
+
+CREATE TABLE IF NOT EXISTS flows_node_view ON CLUSTER '{cluster}' AS flows_node_view_local
+engine=Distributed('{cluster}', default, flows_node_view_local, rand());
+
+CREATE TABLE IF NOT EXISTS flows_policy_view ON CLUSTER '{cluster}' AS flows_policy_view_local
+engine=Distributed('{cluster}', default, flows_policy_view_local, rand());
+
+CREATE TABLE IF NOT EXISTS recommendations ON CLUSTER '{cluster}' AS recommendations_local
+engine=Distributed('{cluster}', default, recommendations_local, rand());
@@ -0,0 +1,2 @@
+ALTER TABLE flows ON CLUSTER '{cluster}' DROP COLUMN IF EXISTS trusted;
+ALTER TABLE flows_local ON CLUSTER '{cluster}' DROP COLUMN IF EXISTS trusted;
@@ -0,0 +1,4 @@
+-- Installations created before trusted was added to create_table.sh lack the column.
+-- The Distributed table does not follow the local table, both are altered.
+ALTER TABLE flows_local ON CLUSTER '{cluster}' ADD COLUMN IF NOT EXISTS trusted UInt8 DEFAULT 0;
+ALTER TABLE flows ON CLUSTER '{cluster}' ADD COLUMN IF NOT EXISTS trusted UInt8 DEFAULT 0;
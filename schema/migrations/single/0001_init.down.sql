@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS recommendations;
+DROP TABLE IF EXISTS flows_policy_view;
+DROP TABLE IF EXISTS flows_node_view;
+DROP TABLE IF EXISTS flows_pod_view;
+DROP TABLE IF EXISTS flows;
@@ -0,0 +1,2 @@
+-- Installations created before trusted was added to create_table.sh lack the column.
+ALTER TABLE flows ADD COLUMN IF NOT EXISTS trusted UInt8 DEFAULT 0;
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+
+	"clickhouse/common/storage"
+)
+
+// ttlTables are the tables with a TTL on timeInserted: flows and its materialized views.
+var ttlTables = []string{"flows", "flows_pod_view", "flows_node_view", "flows_policy_view"}
+
+// The default merge_with_ttl_timeout of MergeTree tables, 4 hours.
+const defaultMergeWithTTLTimeout = 14400
+
+var mergeWithTTLTimeoutSetting = regexp.MustCompile(`merge_with_ttl_timeout = (\d+)`)
+
+// ttlTarget is a table whose TTL is managed, for a materialized view its inner table.
+type ttlTarget struct {
+	name  string
+	table string
+}
+
+// ttlTargets returns the tables to alter: in cluster mode the _local tables, the
+// Distributed tables having no TTL.
+func ttlTargets(connect *sql.DB) ([]ttlTarget, error) {
+	var targets []ttlTarget
+	for i, name := range ttlTables {
+		if cluster {
+			name += "_local"
+		}
+		table := name
+		if i > 0 {
+			inner, err := storage.InnerTable(connect, database, name)
+			if err != nil {
+				return nil, err
+			}
+			table = inner
+		}
+		targets = append(targets, ttlTarget{name: name, table: table})
+	}
+	return targets, nil
+}
+
+// quoteTable returns the quoted name of a table of the database.
+func quoteTable(table string) string {
+	return fmt.Sprintf("`%s`.`%s`", database, table)
+}
+
+// onCluster returns the ON CLUSTER clause in cluster mode.
+func onCluster() string {
+	if cluster {
+		return " ON CLUSTER '{cluster}'"
+	}
+	return ""
+}
+
+// ttlInterval returns the ClickHouse interval of d in its largest whole unit.
+func ttlInterval(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("INTERVAL %d DAY", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("INTERVAL %d HOUR", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("INTERVAL %d MINUTE", d/time.Minute)
+	default:
+		return fmt.Sprintf("INTERVAL %d SECOND", d/time.Second)
+	}
+}
+
+// showTTL prints the TTL and merge_with_ttl_timeout of every TTL table.
+func showTTL(connect *sql.DB) error {
+	targets, err := ttlTargets(connect)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		var statement string
+		if err := connect.QueryRow("SHOW CREATE TABLE " + quoteTable(target.table)).Scan(&statement); err != nil {
+			return fmt.Errorf("failed to read %s: %v", target.name, err)
+		}
+		ttl := "none"
+		if match := ttlClause.FindStringSubmatch(statement); match != nil {
+			ttl = match[1]
+		}
+		timeout := strconv.Itoa(defaultMergeWithTTLTimeout) + " (default)"
+		if match := mergeWithTTLTimeoutSetting.FindStringSubmatch(statement); match != nil {
+			timeout = match[1]
+		}
+		fmt.Printf("%s\tTTL %s\tmerge_with_ttl_timeout %s\n", target.name, ttl, timeout)
+	}
+	return nil
+}
+
+// reportExpiry prints how many rows of every TTL table are older than the TTL, i.e.
+// would be expired by the next TTL merge, and estimates their size.
+func reportExpiry(connect *sql.DB, targets []ttlTarget, ttl time.Duration) error {
+	for _, target := range targets {
+		var total, expired, bytes uint64
+		query := fmt.Sprintf("SELECT COUNT(), countIf(timeInserted < now() - toIntervalSecond(?)) FROM %s", quoteTable(target.table))
+		if err := connect.QueryRow(query, int64(ttl/time.Second)).Scan(&total, &expired); err != nil {
+			return fmt.Errorf("failed to count the expired rows of %s: %v", target.name, err)
+		}
+		if err := connect.QueryRow("SELECT sum(bytes_on_disk) FROM system.parts WHERE database = ? AND table = ? AND active",
+			database, target.table).Scan(&bytes); err != nil {
+			return fmt.Errorf("failed to get the size of %s: %v", target.name, err)
+		}
+		share := 0.0
+		if total > 0 {
+			share = float64(expired) / float64(total)
+		}
+		fmt.Printf("%s: %d of %d rows (%.1f%%, ~%.1f MiB) older than %s\n",
+			target.name, expired, total, share*100, share*float64(bytes)/(1<<20), ttl)
+	}
+	return nil
+}
+
+// setTTL changes the TTL and merge_with_ttl_timeout of flows and of its views alike.
+// The TTL is only applied to existing parts if materialize is set, otherwise rows
+// expire as parts are merged.
+func setTTL(connect *sql.DB, ttl, mergeTimeout time.Duration, materialize, dryRun bool) error {
+	targets, err := ttlTargets(connect)
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		if err := reportExpiry(connect, targets, ttl); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	ctx := clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
+		"materialize_ttl_after_modify": 0,
+	}))
+	for _, target := range targets {
+		var statements []string
+		if ttl > 0 {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s%s MODIFY TTL timeInserted + %s",
+				quoteTable(target.table), onCluster(), ttlInterval(ttl)))
+		}
+		if mergeTimeout > 0 {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s%s MODIFY SETTING merge_with_ttl_timeout = %d",
+				quoteTable(target.table), onCluster(), mergeTimeout/time.Second))
+		}
+		if materialize {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s%s MATERIALIZE TTL", quoteTable(target.table), onCluster()))
+		}
+		for _, statement := range statements {
+			if _, err := connect.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("failed to alter %s: %v", target.name, err)
+			}
+			fmt.Printf("%s: %s\n", target.name, statement)
+		}
+	}
+	return nil
+}
+
+// runTTL runs the ttl command: show, or set with the flags of args.
+func runTTL(connect *sql.DB, args []string) error {
+	if len(args) == 0 || args[0] == "show" {
+		return showTTL(connect)
+	}
+	if args[0] != "set" {
+		return fmt.Errorf("unknown ttl command %q", args[0])
+	}
+	var ttl, mergeTimeout time.Duration
+	var materialize, dryRun bool
+	fs := flag.NewFlagSet("ttl set", flag.ExitOnError)
+	fs.DurationVar(&ttl, "ttl", 0, "new TTL of the rows after timeInserted, e.g. 2h, unchanged if 0")
+	fs.DurationVar(&mergeTimeout, "merge-timeout", 0, "new merge_with_ttl_timeout, the min time between two TTL merges of a partition, unchanged if 0")
+	fs.BoolVar(&materialize, "materialize", false, "apply the TTL to the existing parts right away with MATERIALIZE TTL")
+	fs.BoolVar(&dryRun, "dry-run", false, "only report how many rows the new TTL would expire")
+	fs.Parse(args[1:])
+	if ttl%time.Second != 0 || mergeTimeout%time.Second != 0 || ttl < 0 || mergeTimeout < 0 {
+		return fmt.Errorf("the TTL and the merge timeout must be positive whole seconds")
+	}
+	if ttl == 0 && mergeTimeout == 0 && !materialize {
+		return fmt.Errorf("nothing to change, set -ttl, -merge-timeout or -materialize")
+	}
+	return setTTL(connect, ttl, mergeTimeout, materialize, dryRun)
+}